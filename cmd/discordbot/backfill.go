@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/backfill"
+)
+
+// Running backfills, the jobs themselves live in myAuth.Backfills so they get saved with the config
+
+var (
+	backfillMutex   sync.Mutex
+	backfillCancels = map[string]context.CancelFunc{}
+)
+
+// backfillLearn learns from a message in a channel's history the same way a new message would be
+func backfillLearn(guildId string) backfill.LearnFunc {
+	return func(msg *discordgo.Message) bool {
+		serv, exists := myAuth.Servers.Get(guildId)
+		if !exists || msg.Author == nil || msg.Author.Bot || msg.Author.ID == BotId {
+			return false
+		}
+		// The job already checked the channel when it started, so this skips learnable's channel check
+		if msg.Content == "" || isCommand(msg.Content) {
+			return false
+		}
+		// Anything that came in while the bot was listening has already been learned
+		if serv.HasLearned(msg.ID) {
+			return false
+		}
+		if err := serv.Learn(msg.ID, msg.Author.ID, msg.Content); err != nil {
+			return false
+		}
+		return true
+	}
+}
+
+// reportBackfill posts or updates the progress message for a job
+func reportBackfill(s *discordgo.Session, job *backfill.Job, extra string) {
+	channelId, messageId := job.Report()
+	if channelId == "" {
+		return
+	}
+	msg := job.String() + extra
+	if messageId != "" {
+		if _, err := s.ChannelMessageEdit(channelId, messageId, msg); err == nil {
+			return
+		}
+	}
+	sent, err := s.ChannelMessageSend(channelId, msg)
+	if err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+		return
+	}
+	job.SetReport(channelId, sent.ID)
+}
+
+// startBackfill runs a job in the background
+func startBackfill(s *discordgo.Session, job *backfill.Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backfillMutex.Lock()
+	backfillCancels[job.ChannelID] = cancel
+	backfillMutex.Unlock()
+	logger.Println("Backfilling channel", job.ChannelID, "in guild", job.GuildID)
+	reportBackfill(s, job, "")
+	go func() {
+		err := job.Run(ctx, s, backfillLearn(job.GuildID), func(job *backfill.Job) {
+			reportBackfill(s, job, "")
+			// Save the chain and where the job got to together so it can carry on after a restart
+			if serv, exists := myAuth.Servers.Get(job.GuildID); exists {
+				saveChanges(serv)
+			}
+		})
+		backfillMutex.Lock()
+		delete(backfillCancels, job.ChannelID)
+		backfillMutex.Unlock()
+		switch {
+		case errors.Is(err, context.Canceled):
+			// Stopped or shutting down, stopBackfill removes the job if it shouldn't come back
+			return
+		case err != nil:
+			logger.Println("Non-Fatal Error: Backfill of channel", job.ChannelID, "failed:", err.Error())
+			reportBackfill(s, job, "\nStopped with an error, it'll pick back up after a restart.")
+			return
+		}
+		myAuth.Backfills.Remove(job.ChannelID)
+		if serv, exists := myAuth.Servers.Get(job.GuildID); exists {
+			saveChanges(serv)
+		}
+		logger.Println("Finished backfilling channel", job.ChannelID, "in guild", job.GuildID, job)
+	}()
+}
+
+// stopBackfill cancels the job for a channel and forgets about it
+func stopBackfill(channelId string) error {
+	backfillMutex.Lock()
+	if cancel, ok := backfillCancels[channelId]; ok {
+		cancel()
+	}
+	backfillMutex.Unlock()
+	return myAuth.Backfills.Remove(channelId)
+}
+
+// pauseBackfills cancels every running job but keeps them so they resume on the next start
+func pauseBackfills() {
+	backfillMutex.Lock()
+	defer backfillMutex.Unlock()
+	for _, cancel := range backfillCancels {
+		cancel()
+	}
+}
+
+// resumeBackfills starts every job that was running when the bot last shut down
+func resumeBackfills(s *discordgo.Session) {
+	for _, job := range myAuth.Backfills.All() {
+		if _, exists := myAuth.Servers.Get(job.GuildID); !exists {
+			myAuth.Backfills.Remove(job.ChannelID)
+			continue
+		}
+		startBackfill(s, job)
+	}
+}
@@ -0,0 +1,1297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/backfill"
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/router"
+	"github.com/danielh2942/markov_thingy/pkg/servsync"
+)
+
+// The commands themselves, these get registered with the router in main
+// Anything returned as an error is shown to the person who ran the command
+
+var (
+	errNotLocked   = errors.New("this server isn't set up yet, use lock in the channel I should learn from")
+	errSaveOff     = errors.New("saving is turned off")
+	errWrongChan   = errors.New("that only works in channels I talk in")
+	errGenerate    = errors.New("an error occurred while generating a sentence")
+	errNotOptedIn  = errors.New("they haven't opted in, they can with optin")
+	errNotEnough   = errors.New("I haven't learned enough from them yet")
+	errUnsupported = errors.New("this server's chain doesn't support that")
+	errNeedRole    = errors.New("give me a role")
+	errDisabled    = errors.New("that's turned off in this server")
+	errNotLearning = errors.New("I don't learn from this channel")
+	errBadDuration = errors.New("idle times look like 90m or 3h")
+	errNoSchedule  = errors.New("there's no schedule with that number, check schedules")
+	errTooBig      = errors.New("that's too big for discord to upload")
+	errDownload    = errors.New("couldn't download the attachment")
+	errOtherGuild  = errors.New("that channel isn't in this server")
+)
+
+// Backfills without a limit or cutoff stop after this many messages
+const defaultBackfillLimit = 1000
+
+// Biggest file that can be uploaded without boosts
+const maxUpload = 10 << 20
+
+// Admin commands need this by default, roles can be allow-listed per server with adminroles
+const manageServer = discordgo.PermissionManageServer
+
+// configMutex stops two goroutines writing the config at the same time
+var configMutex sync.Mutex
+
+// saveAll saves every server's chain then writes the config, for shutting down
+func saveAll() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if err := myAuth.Servers.SaveAll(); err != nil {
+		return err
+	}
+	return writeConfig()
+}
+
+// saveConfig writes the config back to config.json, the chains are left alone
+func saveConfig() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return writeConfig()
+}
+
+// saveServer saves one server's chain and the config, for when nothing else has changed
+func saveServer(serv *servsync.ServSync) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if err := serv.Save(); err != nil {
+		return err
+	}
+	return writeConfig()
+}
+
+// writeConfig writes config.json without touching the chains, the caller must hold configMutex
+// It goes to a temp file first so a crash halfway through doesn't leave half a config
+func writeConfig() error {
+	outp, err := json.MarshalIndent(&myAuth, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile("config.json.tmp", outp, 0644); err != nil {
+		return err
+	}
+	return os.Rename("config.json.tmp", "config.json")
+}
+
+// server gets the ServSync for the guild a command was run in, nil if there isn't one
+func server(ctx *router.Context) *servsync.ServSync {
+	serv, _ := myAuth.Servers.Get(ctx.GuildID)
+	return serv
+}
+
+// authorize lets people run admin commands if they have the permissions or one of the server's admin roles
+func authorize(ctx *router.Context, cmd *router.Command) error {
+	err := router.DefaultAuthorizer(ctx, cmd)
+	if !errors.Is(err, router.ErrForbidden) {
+		return err
+	}
+	if serv := server(ctx); serv != nil && ctx.Member != nil && serv.HasAdminRole(ctx.Member.Roles) {
+		return nil
+	}
+	return fmt.Errorf("%w, %s needs %s or one of this server's admin roles", router.ErrForbidden, cmd.Name, router.PermissionNames(cmd.Permissions))
+}
+
+// Checks used by the commands below
+
+func needServer(ctx *router.Context) error {
+	if server(ctx) == nil {
+		return errNotLocked
+	}
+	return nil
+}
+
+func needReplyChannel(ctx *router.Context) error {
+	if serv := server(ctx); serv == nil || !canReply(ctx.Session, serv, ctx.ChannelID) {
+		return errWrongChan
+	}
+	return nil
+}
+
+// needManageServer stops allow-listed roles from handing out admin to other roles
+func needManageServer(ctx *router.Context) error {
+	ok, err := router.HasPermissions(ctx, manageServer)
+	if err == nil && !ok {
+		err = fmt.Errorf("%w, only people with %s can change the admin roles", router.ErrForbidden, router.PermissionNames(manageServer))
+	}
+	if err != nil {
+		logger.Println("Rejected", ctx.Command.Name, "from user", ctx.Author.ID, "in guild", ctx.GuildID, "reason:", err.Error())
+	}
+	return err
+}
+
+// needFeature makes sure a feature hasn't been turned off in the server
+func needFeature(feature string) router.Check {
+	return func(ctx *router.Context) error {
+		if serv := server(ctx); serv != nil && !serv.Settings().Enabled(feature) {
+			return errDisabled
+		}
+		return nil
+	}
+}
+
+func needSave(ctx *router.Context) error {
+	if !progFlags.Save {
+		return errSaveOff
+	}
+	return nil
+}
+
+// suggestNumbers builds an autocomplete function that suggests the server's current value and some common ones
+func suggestNumbers(current func(servsync.Settings) uint64, suggestions ...uint64) router.AutocompleteFunc {
+	return func(ctx *router.Context, typed string) []*discordgo.ApplicationCommandOptionChoice {
+		settings := servsync.Defaults
+		if serv := server(ctx); serv != nil {
+			settings = serv.Settings()
+		}
+		now := current(settings)
+		choices := []*discordgo.ApplicationCommandOptionChoice{{
+			Name:  "Current (" + strconv.FormatUint(now, 10) + ")",
+			Value: now,
+		}}
+		for _, v := range suggestions {
+			str := strconv.FormatUint(v, 10)
+			if v != now && (typed == "" || str[:min(len(typed), len(str))] == typed) {
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: str, Value: v})
+			}
+		}
+		return choices
+	}
+}
+
+// barkCommand says something
+func barkCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	msg, err := serv.MarkovChain.GenerateSentence(serv.Settings().MaxLength)
+	if err != nil {
+		logger.Println("Non-fatal Error:", err.Error())
+		return "", errGenerate
+	}
+	if ctx.Interaction != nil || canReply(ctx.Session, serv, ctx.ChannelID) {
+		return msg, nil
+	}
+	// Prefix barks from elsewhere go to the main channel
+	if _, err := ctx.Session.ChannelMessageSend(serv.ChanId, msg); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// lockCommand makes a channel the server's main channel, setting the server up if it's new
+func lockCommand(ctx *router.Context) (string, error) {
+	if serv := server(ctx); serv == nil {
+		mc := servsync.New(ctx.ChannelID)
+		if err := applyDecay(mc); err != nil {
+			logger.Println("Non-Fatal Error:", err.Error())
+		}
+		myAuth.Servers.Set(ctx.GuildID, mc)
+		saveChanges(mc)
+	} else {
+		serv.SetMainChannel(ctx.ChannelID)
+		saveSettings()
+	}
+	logger.Println("Main channel for guild", ctx.GuildID, "is now", ctx.ChannelID)
+	if _, ok := ctx.IntArg("backfill"); ok {
+		if _, err := backfillCommand(ctx); err != nil {
+			return "", err
+		}
+	}
+	return "This is my main channel now, I'll learn from it and talk in it.", nil
+}
+
+// backfillCommand starts learning from the channel's history
+func backfillCommand(ctx *router.Context) (string, error) {
+	if !canLearn(ctx.Session, server(ctx), ctx.ChannelID) {
+		return "", errNotLearning
+	}
+	limit, hasLimit := ctx.IntArg("backfill")
+	if !hasLimit {
+		limit, hasLimit = ctx.IntArg("messages")
+	}
+	var cutoff time.Time
+	if days, ok := ctx.IntArg("days"); ok {
+		cutoff = time.Now().AddDate(0, 0, -int(days))
+	} else if !hasLimit {
+		limit = defaultBackfillLimit
+	}
+	job := backfill.New(ctx.GuildID, ctx.ChannelID, int(limit), cutoff)
+	job.SetReport(ctx.ChannelID, "")
+	if err := myAuth.Backfills.Add(job); err != nil {
+		return "", err
+	}
+	startBackfill(ctx.Session, job)
+	return "", nil
+}
+
+// backfillStopCommand cancels a backfill
+func backfillStopCommand(ctx *router.Context) (string, error) {
+	if err := stopBackfill(ctx.ChannelID); err != nil {
+		return "", err
+	}
+	saveSettings()
+	return "Backfill stopped, I'll keep what I learned so far.", nil
+}
+
+// channelArg gets the channel a command is about, defaulting to the one it was run in
+// Prefix commands take any ID, so it has to be checked it's actually in this server
+func channelArg(ctx *router.Context) (string, error) {
+	id := ctx.StringArg("channel")
+	if id == "" {
+		return ctx.ChannelID, nil
+	}
+	if !channelInGuild(ctx.Session, id, ctx.GuildID) {
+		return "", errOtherGuild
+	}
+	return id, nil
+}
+
+// channelInGuild checks a channel exists and belongs to a server
+func channelInGuild(s *discordgo.Session, channelId string, guildId string) bool {
+	ch, err := s.State.Channel(channelId)
+	if err != nil {
+		if ch, err = s.Channel(channelId); err != nil {
+			return false
+		}
+	}
+	return ch.GuildID == guildId
+}
+
+// channelCommand sets whether a channel is learned from and/or talked in
+func channelCommand(ctx *router.Context) (string, error) {
+	channelId, err := channelArg(ctx)
+	if err != nil {
+		return "", err
+	}
+	mode := ctx.StringArg("mode")
+	server(ctx).SetChannel(channelId, mode == "learn" || mode == "both", mode == "reply" || mode == "both")
+	saveSettings()
+	logger.Println("Channel", channelId, "in guild", ctx.GuildID, "set to", mode, "by user", ctx.Author.ID)
+	switch mode {
+	case "learn":
+		return "I'll learn from <#" + channelId + "> but won't talk there.", nil
+	case "reply":
+		return "I'll talk in <#" + channelId + "> but won't learn from it.", nil
+	case "both":
+		return "I'll learn from and talk in <#" + channelId + ">.", nil
+	}
+	return "I'll leave <#" + channelId + "> alone.", nil
+}
+
+// channelOddsCommand sets the reply odds for one channel
+func channelOddsCommand(ctx *router.Context) (string, error) {
+	channelId, err := channelArg(ctx)
+	if err != nil {
+		return "", err
+	}
+	var odds *uint
+	if val, ok := ctx.IntArg("rate"); ok {
+		rate := uint(val)
+		odds = &rate
+	}
+	if !server(ctx).SetChannelOdds(channelId, odds) {
+		return "", errors.New("<#" + channelId + "> isn't set up, use channel first")
+	}
+	saveSettings()
+	if odds == nil {
+		return "<#" + channelId + "> uses the server's reply odds again.", nil
+	}
+	return "I'll reply to " + strconv.FormatUint(uint64(*odds), 10) + "/100 messages in <#" + channelId + ">.", nil
+}
+
+// ignoreCommand stops a channel from ever being learned from
+func ignoreCommand(ctx *router.Context) (string, error) {
+	channelId, err := channelArg(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !server(ctx).Ignore(channelId) {
+		return "<#" + channelId + "> was already ignored.", nil
+	}
+	saveSettings()
+	return "I'll never learn from <#" + channelId + ">.", nil
+}
+
+// unignoreCommand takes a channel off the ignore list
+func unignoreCommand(ctx *router.Context) (string, error) {
+	channelId, err := channelArg(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !server(ctx).Unignore(channelId) {
+		return "<#" + channelId + "> wasn't ignored.", nil
+	}
+	saveSettings()
+	return "<#" + channelId + "> isn't ignored anymore.", nil
+}
+
+// channelsCommand lists the channels the bot learns from and talks in
+func channelsCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	channels := serv.Channels()
+	ids := []string{}
+	for id := range channels {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	output := ""
+	for _, id := range ids {
+		ch := channels[id]
+		line := "<#" + id + ">:"
+		if id == serv.ChanId {
+			line += " main,"
+		}
+		if ch.Learn {
+			line += " learn"
+		}
+		if ch.Reply {
+			line += " reply (" + strconv.FormatUint(uint64(serv.Odds(id)), 10) + "/100)"
+		}
+		output += line + "\n"
+	}
+	if ignored := serv.Ignored(); len(ignored) > 0 {
+		output += "Ignored:"
+		for _, id := range ignored {
+			output += " <#" + id + ">"
+		}
+		output += "\n"
+	}
+	if output == "" {
+		return "I'm not learning from or talking in any channels, use channel to set some up.", nil
+	}
+	return strings.TrimSuffix(output, "\n"), nil
+}
+
+// saveCommand forces a save - this is for debugging
+func saveCommand(ctx *router.Context) (string, error) {
+	if err := server(ctx).Save(); err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+		return "", errors.New("saving failed")
+	}
+	logger.Println("Saving checkpoint.")
+	return "Saved.", nil
+}
+
+// setBackupCommand changes how many messages there are between saves
+func setBackupCommand(ctx *router.Context) (string, error) {
+	val, _ := ctx.IntArg("messages")
+	server(ctx).SetBackupFreq(uint64(val))
+	saveSettings()
+	logger.Println("Backup frequency for guild", ctx.GuildID, "changed to every ", val, "Messages!")
+	return "Saving every " + strconv.FormatInt(val, 10) + " messages.", nil
+}
+
+// adjustRateCommand changes the odds of the bot replying to a message
+func adjustRateCommand(ctx *router.Context) (string, error) {
+	val, _ := ctx.IntArg("rate")
+	server(ctx).SetPostingOdds(uint(val))
+	saveSettings()
+	return "I'll reply to " + strconv.FormatInt(val, 10) + "/100 messages.", nil
+}
+
+// targetRateCommand changes how many messages an hour the bot aims for in each channel
+func targetRateCommand(ctx *router.Context) (string, error) {
+	val, _ := ctx.IntArg("messages")
+	server(ctx).SetTargetRate(uint(val))
+	saveSettings()
+	if val == 0 {
+		return "I'll go back to replying by the odds.", nil
+	}
+	return "I'll aim for about " + strconv.FormatInt(val, 10) + " messages an hour in each channel.", nil
+}
+
+// quietHoursCommand changes when the bot doesn't post on its own
+func quietHoursCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	from, _ := ctx.IntArg("from")
+	to, _ := ctx.IntArg("to")
+	quiet := servsync.QuietHours{From: int(from), To: int(to), Timezone: serv.Settings().QuietHours.Timezone}
+	if tz := ctx.StringArg("timezone"); tz != "" {
+		quiet.Timezone = tz
+	}
+	if err := serv.SetQuietHours(quiet); err != nil {
+		return "", err
+	}
+	saveSettings()
+	if from == to {
+		return "No more quiet hours, I'll post whenever.", nil
+	}
+	return "I'll keep quiet " + quiet.String() + ", unless someone talks to me.", nil
+}
+
+// reactionsCommand changes which reactions on the bot's messages count as good or bad
+func reactionsCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	good := ctx.StringArg("kind") == "good"
+	var emoji []string
+	switch input := ctx.StringArg("emoji"); input {
+	case "default":
+	case "none":
+		emoji = []string{}
+	default:
+		for _, field := range strings.Fields(input) {
+			emoji = append(emoji, emojiName(field))
+		}
+		if len(emoji) > servsync.MaxReactionEmoji {
+			return "", servsync.ErrTooManyEmoji
+		}
+	}
+	serv.SetReactions(good, emoji)
+	saveSettings()
+	settings := serv.Settings()
+	if good {
+		return "Good reactions: " + strings.Join(settings.GoodEmoji, " "), nil
+	}
+	return "Bad reactions: " + strings.Join(settings.BadEmoji, " "), nil
+}
+
+// exportCommand uploads the server's chain and settings as a zip
+func exportCommand(ctx *router.Context) (string, error) {
+	archive := &bytes.Buffer{}
+	if err := server(ctx).Export(archive); err != nil {
+		return "", err
+	}
+	if archive.Len() > maxUpload {
+		return "", errTooBig
+	}
+	logger.Println("Exported guild", ctx.GuildID, "for user", ctx.Author.ID)
+	name := "markov_" + ctx.GuildID + "_" + time.Now().Format("20060102") + ".zip"
+	return "", ctx.ReplyFile("Here's everything I've learned in this server.", &discordgo.File{Name: name, ContentType: "application/zip", Reader: archive})
+}
+
+// importCommand loads an exported zip into the server, replacing or merging with what's there
+func importCommand(ctx *router.Context) (string, error) {
+	attachment := ctx.AttachmentArg("archive")
+	if attachment == nil {
+		return "", errDownload
+	}
+	if int64(attachment.Size) > servsync.MaxImportSize {
+		return "", servsync.ErrArchiveTooBig
+	}
+	resp, err := ctx.Session.Client.Get(attachment.URL)
+	if err != nil {
+		return "", errDownload
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errDownload
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, servsync.MaxImportSize+1))
+	if err != nil {
+		return "", errDownload
+	}
+	if int64(len(data)) > servsync.MaxImportSize {
+		return "", servsync.ErrArchiveTooBig
+	}
+
+	serv := server(ctx)
+	merge := ctx.StringArg("mode") == "merge"
+	inGuild := func(channelId string) bool { return channelInGuild(ctx.Session, channelId, ctx.GuildID) }
+	if err := serv.Import(bytes.NewReader(data), int64(len(data)), merge, inGuild); err != nil {
+		return "", err
+	}
+	if err := applyDecay(serv); err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+	}
+	saveChanges(serv)
+	logger.Println("Imported", attachment.Filename, "into guild", ctx.GuildID, "by user", ctx.Author.ID, "merge:", merge)
+	if merge {
+		return "Merged that in with what I already knew.", nil
+	}
+	return "Swapped in that chain and its settings.", nil
+}
+
+// scheduleCommand adds a schedule for posting in a channel
+func scheduleCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	channelId, err := channelArg(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !canReply(ctx.Session, serv, channelId) {
+		return "", errWrongChan
+	}
+	sch, err := parseWhen(ctx.StringArg("mode"), channelId, ctx.StringArg("when"), serv.Settings().QuietHours.Timezone)
+	if err != nil {
+		return "", err
+	}
+	serv.AddSchedule(sch)
+	saveSettings()
+	logger.Println("Schedule", sch.ID, "added in guild", ctx.GuildID, "by user", ctx.Author.ID)
+	return "Added schedule " + sch.String(), nil
+}
+
+// schedulesCommand lists the server's schedules
+func schedulesCommand(ctx *router.Context) (string, error) {
+	schedules := server(ctx).Schedules()
+	if len(schedules) == 0 {
+		return "Nothing's scheduled.", nil
+	}
+	output := "Schedules:"
+	for _, sch := range schedules {
+		output += "\n" + sch.String()
+	}
+	if !server(ctx).Settings().Enabled(servsync.FeatureSchedules) {
+		output += "\n(schedules are turned off right now)"
+	}
+	return output, nil
+}
+
+// unscheduleCommand removes a schedule
+func unscheduleCommand(ctx *router.Context) (string, error) {
+	id, _ := ctx.IntArg("id")
+	if !server(ctx).RemoveSchedule(int(id)) {
+		return "", errNoSchedule
+	}
+	saveSettings()
+	logger.Println("Schedule", id, "removed in guild", ctx.GuildID, "by user", ctx.Author.ID)
+	return "Schedule " + strconv.FormatInt(id, 10) + " removed.", nil
+}
+
+// pruneCommand compacts a server's chain
+func pruneCommand(ctx *router.Context) (string, error) {
+	threshold := progFlags.PruneMin
+	if val, ok := ctx.IntArg("threshold"); ok {
+		threshold = uint(val)
+	}
+	stats, err := server(ctx).Prune(threshold)
+	if err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+		return "", errUnsupported
+	}
+	logger.Println("Pruned guild", ctx.GuildID, stats)
+	return stats.String(), nil
+}
+
+// ytRandomCommand finds a random youtube video using a generated search query
+func ytRandomCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	// Make sure that it always returns a video
+	for {
+		mq, err := serv.MarkovChain.GenerateSentence(20)
+		if err != nil {
+			logger.Println("Failed to generate Sentence, reason:", err.Error())
+			return "", errGenerate
+		}
+		vid, err := ytListener.GetRandomVid(mq)
+		if err == nil {
+			return "Video found with Query \"" + mq + "\"\n" + vid, nil
+		}
+	}
+}
+
+// optInCommand starts tracking a user's messages
+func optInCommand(ctx *router.Context) (string, error) {
+	server(ctx).OptIn(ctx.Author.ID)
+	saveSettings()
+	return "Your messages will now be tracked so I can impersonate you. Use " + ctx.Prefix + "optout to stop.", nil
+}
+
+// optOutCommand stops tracking a user's messages
+func optOutCommand(ctx *router.Context) (string, error) {
+	server(ctx).OptOut(ctx.Author.ID)
+	saveChanges(server(ctx))
+	return "Your messages are no longer tracked and what was tracked has been forgotten.", nil
+}
+
+// forgetMeCommand takes everything the person running it said back out of the chain
+func forgetMeCommand(ctx *router.Context) (string, error) {
+	return forgetUser(ctx, ctx.Author.ID, "you")
+}
+
+// forgetCommand takes everything someone said back out of the chain
+func forgetCommand(ctx *router.Context) (string, error) {
+	user := ctx.UserArg("user")
+	// Named rather than mentioned so they don't get pinged
+	who := user.Username
+	if who == "" {
+		who = "them"
+	}
+	return forgetUser(ctx, user.ID, who)
+}
+
+func forgetUser(ctx *router.Context, userId string, who string) (string, error) {
+	stats, err := server(ctx).Forget(userId)
+	if err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+		return "", errUnsupported
+	}
+	saveChanges(server(ctx))
+	logger.Println("Forgot user", userId, "in guild", ctx.GuildID, "for user", ctx.Author.ID, stats)
+	if stats.Messages == 0 {
+		return "I don't have anything tracked from " + who + ". Messages from before I started tracking who said what can't be found.", nil
+	}
+	return "Forgot " + stats.String() + " from " + who + ". Messages from before I started tracking who said what can't be found.", nil
+}
+
+// impersonateCommand says something the way a user would
+func impersonateCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	user := ctx.UserArg("user")
+	if !serv.IsOptedIn(user.ID) {
+		return "", errNotOptedIn
+	}
+	msg, err := serv.Impersonate(user.ID, serv.Settings().MaxLength, progFlags.ImpersonateMix)
+	if err != nil {
+		logger.Println("Non-fatal Error:", err.Error())
+		return "", errNotEnough
+	}
+	return "<@" + user.ID + ">: " + msg, nil
+}
+
+// Entries shown in each of the top lists in stats
+const statsTop = 5
+
+// statsCommand shows what's inside the server's chain
+func statsCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	reporter, ok := serv.MarkovChain.(markovcommon.StatsReporter)
+	if !ok {
+		return "", errUnsupported
+	}
+	stats := reporter.Stats(statsTop)
+	size := "Not saved yet"
+	if info, err := os.Stat(serv.FileName); err == nil {
+		size = formatBytes(info.Size())
+	}
+
+	topWords := []string{}
+	for idx, word := range stats.TopWords {
+		topWords = append(topWords, fmt.Sprintf("%d. %s (%d)", idx+1, word.Word, word.Count))
+	}
+	topBigrams := []string{}
+	for idx, bigram := range stats.TopBigrams {
+		topBigrams = append(topBigrams, fmt.Sprintf("%d. %s %s (%d)", idx+1, bigram.From, bigram.To, bigram.Count))
+	}
+	orNothing := func(list []string) string {
+		if len(list) == 0 {
+			return "Nothing yet"
+		}
+		return strings.Join(list, "\n")
+	}
+
+	return "", ctx.ReplyEmbed(&discordgo.MessageEmbed{
+		Title: "What I know here",
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Words", Value: strconv.Itoa(stats.Words), Inline: true},
+			{Name: "Word links", Value: strconv.Itoa(stats.Edges), Inline: true},
+			{Name: "Start words", Value: strconv.Itoa(stats.StartWords), Inline: true},
+			{Name: "Messages learned", Value: strconv.FormatUint(uint64(stats.Learned), 10), Inline: true},
+			{Name: "Branching", Value: fmt.Sprintf("%.2f ways per word", stats.Branching), Inline: true},
+			{Name: "File size", Value: size, Inline: true},
+			{Name: "Top words", Value: orNothing(topWords), Inline: true},
+			{Name: "Top pairs", Value: orNothing(topBigrams), Inline: true},
+		},
+	})
+}
+
+// formatBytes writes a size out in whatever unit makes it readable
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return ""
+}
+
+// howLikelyCommand scores some text against the server's chain
+func howLikelyCommand(ctx *router.Context) (string, error) {
+	scorer, ok := server(ctx).MarkovChain.(markovcommon.Scorer)
+	if !ok {
+		return "", errUnsupported
+	}
+	score, err := scorer.Score(ctx.StringArg("text"))
+	if err != nil {
+		return "", err
+	}
+	// Long sentences are too unlikely to write out, so big odds go as a power of 10
+	odds := fmt.Sprintf("%.0f", 1/score.Probability())
+	if digits := -score.LogProb / math.Ln10; digits >= 7 {
+		odds = fmt.Sprintf("10^%.0f", digits)
+	}
+	output := fmt.Sprintf("Perplexity %.1f over %d steps, about a 1 in %s chance of me saying that.", score.Perplexity, score.Steps, odds)
+	if len(score.Unknown) > 0 {
+		output += "\nNever seen: `" + strings.Join(score.Unknown, "` `") + "`"
+	}
+	return output, nil
+}
+
+// Followers or predecessors shown by next and prev
+const neighbourTop = 10
+
+// explorer gets the server's chain if it can be poked at word by word
+func explorer(ctx *router.Context) (markovcommon.Explorer, error) {
+	chain, ok := server(ctx).MarkovChain.(markovcommon.Explorer)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return chain, nil
+}
+
+// formatNeighbours lists words with their chances, one per line
+func formatNeighbours(title string, neighbours []markovcommon.Neighbour) string {
+	if len(neighbours) == 0 {
+		return title + " nothing, as far as I know."
+	}
+	output := title + "\n"
+	for _, n := range neighbours {
+		output += fmt.Sprintf("`%s` %.1f%% (%d)\n", n.Word, n.Probability*100, n.Count)
+	}
+	return output
+}
+
+// nextCommand shows what usually comes after a word
+func nextCommand(ctx *router.Context) (string, error) {
+	chain, err := explorer(ctx)
+	if err != nil {
+		return "", err
+	}
+	word := ctx.StringArg("word")
+	next, err := chain.Next(word, neighbourTop)
+	if err != nil {
+		return "", err
+	}
+	return formatNeighbours("After `"+word+"` comes", next), nil
+}
+
+// prevCommand shows what usually comes before a word
+func prevCommand(ctx *router.Context) (string, error) {
+	chain, err := explorer(ctx)
+	if err != nil {
+		return "", err
+	}
+	word := ctx.StringArg("word")
+	prev, err := chain.Prev(word, neighbourTop)
+	if err != nil {
+		return "", err
+	}
+	return formatNeighbours("Before `"+word+"` comes", prev), nil
+}
+
+// pathCommand shows the most likely way to get from one word to another
+func pathCommand(ctx *router.Context) (string, error) {
+	chain, err := explorer(ctx)
+	if err != nil {
+		return "", err
+	}
+	path, chance, err := chain.Path(ctx.StringArg("from"), ctx.StringArg("to"))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("`%s` (%.3g%% likely)", strings.Join(path, " "), chance*100), nil
+}
+
+// setLengthCommand changes the most words the bot will say at once
+func setLengthCommand(ctx *router.Context) (string, error) {
+	val, _ := ctx.IntArg("words")
+	server(ctx).SetMaxLength(int(val))
+	saveSettings()
+	return "I'll say at most " + strconv.FormatInt(val, 10) + " words at a time.", nil
+}
+
+// featureCommand turns a feature on or off for the server
+func featureCommand(ctx *router.Context) (string, error) {
+	feature := ctx.StringArg("feature")
+	on, _ := ctx.BoolArg("enabled")
+	if err := server(ctx).SetFeature(feature, on); err != nil {
+		return "", err
+	}
+	saveSettings()
+	logger.Println("Feature", feature, "set to", on, "in guild", ctx.GuildID, "by user", ctx.Author.ID)
+	if on {
+		return feature + " is now on.", nil
+	}
+	return feature + " is now off.", nil
+}
+
+// settingsCommand shows the server's settings
+func settingsCommand(ctx *router.Context) (string, error) {
+	return "```" + server(ctx).Settings().String() + "```", nil
+}
+
+// resetSettingsCommand puts the server back on the defaults
+func resetSettingsCommand(ctx *router.Context) (string, error) {
+	server(ctx).ResetSettings()
+	saveSettings()
+	return "Settings are back to the defaults.", nil
+}
+
+// saveSettings writes settings changes to the config so they survive a restart
+func saveSettings() {
+	if !progFlags.Save {
+		return
+	}
+	if err := saveConfig(); err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+	}
+}
+
+// saveChanges is saveSettings for when a server's chain changed too and it shouldn't wait for the next backup
+// Like a new server, which needs its chain file to exist for the config to load, or someone being forgotten
+func saveChanges(serv *servsync.ServSync) {
+	if !progFlags.Save {
+		return
+	}
+	if err := saveServer(serv); err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+	}
+}
+
+// roleName gets a role's name so listing roles doesn't ping them
+func roleName(ctx *router.Context, roleId string) string {
+	if role, err := ctx.Session.State.Role(ctx.GuildID, roleId); err == nil {
+		return role.Name
+	}
+	return roleId
+}
+
+// adminRolesCommand manages the roles that can run admin commands
+func adminRolesCommand(ctx *router.Context) (string, error) {
+	serv := server(ctx)
+	roleId := ctx.StringArg("role")
+	switch ctx.StringArg("action") {
+	case "add":
+		if roleId == "" {
+			return "", errNeedRole
+		}
+		if !serv.AllowRole(roleId) {
+			return roleName(ctx, roleId) + " can already run admin commands.", nil
+		}
+		logger.Println("Role", roleId, "allowed to run admin commands in guild", ctx.GuildID, "by user", ctx.Author.ID)
+		saveSettings()
+		return roleName(ctx, roleId) + " can now run admin commands.", nil
+	case "remove":
+		if roleId == "" {
+			return "", errNeedRole
+		}
+		if !serv.DisallowRole(roleId) {
+			return roleName(ctx, roleId) + " wasn't an admin role.", nil
+		}
+		logger.Println("Role", roleId, "no longer allowed to run admin commands in guild", ctx.GuildID, "by user", ctx.Author.ID)
+		saveSettings()
+		return roleName(ctx, roleId) + " can't run admin commands anymore.", nil
+	}
+	roles := serv.AdminRoles()
+	if len(roles) == 0 {
+		return "Only people with " + router.PermissionNames(manageServer) + " can run admin commands.", nil
+	}
+	names := []string{}
+	for _, id := range roles {
+		names = append(names, roleName(ctx, id))
+	}
+	return "Admin commands can be run by people with " + router.PermissionNames(manageServer) + " and these roles: " + strings.Join(names, ", "), nil
+}
+
+// helpCommand lists the commands
+func helpCommand(ctx *router.Context) (string, error) {
+	return commandRouter.Help(ctx.Prefix), nil
+}
+
+// registerCommands sets up every command on the router
+func registerCommands(r *router.Router) {
+	r.Authorize = authorize
+	r.Checks = append(r.Checks, needCooldown)
+	r.Register(
+		&router.Command{
+			Name:        "help",
+			Description: "Shows this",
+			Handler:     helpCommand,
+		},
+		&router.Command{
+			Name:        "bark",
+			Description: "Say Something",
+			Checks:      []router.Check{needServer},
+			Handler:     barkCommand,
+		},
+		&router.Command{
+			Name:        "ytrandom",
+			Description: "Random Youtube Video from search query generated from input data",
+			Checks:      []router.Check{needServer, needReplyChannel, needFeature(servsync.FeatureYoutube)},
+			Slow:        true,
+			Handler:     ytRandomCommand,
+		},
+		&router.Command{
+			Name:        "optin",
+			Description: "Let the bot track your messages so it can impersonate you",
+			Checks:      []router.Check{needServer},
+			Handler:     optInCommand,
+		},
+		&router.Command{
+			Name:        "optout",
+			Description: "Stop tracking your messages",
+			Checks:      []router.Check{needServer},
+			Handler:     optOutCommand,
+		},
+		&router.Command{
+			Name:        "forgetme",
+			Description: "Forget everything you've taught the bot",
+			Checks:      []router.Check{needServer},
+			Handler:     forgetMeCommand,
+		},
+		&router.Command{
+			Name:        "forget",
+			Description: "Forget everything someone has taught the bot",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "user", Description: "Who to forget", Type: router.ArgUser, Required: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: forgetCommand,
+		},
+		&router.Command{
+			Name:        "impersonate",
+			Description: "Say something the way they would",
+			Args: []*router.Arg{
+				{Name: "user", Description: "Who to impersonate", Type: router.ArgUser, Required: true},
+			},
+			Checks:  []router.Check{needServer, needFeature(servsync.FeatureImpersonate)},
+			Handler: impersonateCommand,
+		},
+		&router.Command{
+			Name:        "stats",
+			Description: "Shows how much I've learned in this server",
+			Checks:      []router.Check{needServer},
+			Handler:     statsCommand,
+		},
+		&router.Command{
+			Name:        "next",
+			Description: "Shows the words most likely to come after a word",
+			Args: []*router.Arg{
+				{Name: "word", Description: "The word", Required: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: nextCommand,
+		},
+		&router.Command{
+			Name:        "prev",
+			Description: "Shows the words most likely to come before a word",
+			Args: []*router.Arg{
+				{Name: "word", Description: "The word", Required: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: prevCommand,
+		},
+		&router.Command{
+			Name:        "path",
+			Description: "Shows the most likely way to get from one word to another",
+			Args: []*router.Arg{
+				{Name: "from", Description: "Word to start at", Required: true},
+				{Name: "to", Description: "Word to end at", Required: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: pathCommand,
+		},
+		&router.Command{
+			Name:        "howlikely",
+			Description: "Shows how likely I'd be to say something",
+			Args: []*router.Arg{
+				{Name: "text", Description: "What to score", Required: true, Rest: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: howLikelyCommand,
+		},
+		&router.Command{
+			Name:        "lock",
+			Description: "Make this the main channel, learning from and talking in it",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "backfill", Description: "Learn from this many messages of history too", Type: router.ArgInt, Min: router.Bound(1)},
+			},
+			Handler: lockCommand,
+		},
+		&router.Command{
+			Name:        "backfill",
+			Description: "Learn from this channel's history, by default the last " + strconv.Itoa(defaultBackfillLimit) + " messages",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "messages", Description: "Most messages to learn from", Type: router.ArgInt, Min: router.Bound(1)},
+				{Name: "days", Description: "How many days back to go", Type: router.ArgInt, Min: router.Bound(1)},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: backfillCommand,
+		},
+		&router.Command{
+			Name:        "backfillstop",
+			Description: "Stop learning from this channel's history",
+			Permissions: manageServer,
+			Checks:      []router.Check{needServer},
+			Handler:     backfillStopCommand,
+		},
+		&router.Command{
+			Name:        "save",
+			Description: "Save the database now",
+			Permissions: manageServer,
+			Checks:      []router.Check{needSave, needServer},
+			Handler:     saveCommand,
+		},
+		&router.Command{
+			Name:        "setbackup",
+			Description: "Change how many messages there are between saves",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{
+					Name:         "messages",
+					Description:  "Messages between saves",
+					Type:         router.ArgInt,
+					Required:     true,
+					Min:          router.Bound(1),
+					Autocomplete: suggestNumbers(func(s servsync.Settings) uint64 { return s.BackupFreq }, 50, 100, 250, 500, 1000),
+				},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: setBackupCommand,
+		},
+		&router.Command{
+			Name:        "adjustrate",
+			Description: "Chances out of 100 that the bot will say something",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{
+					Name:         "rate",
+					Description:  "Chances out of 100",
+					Type:         router.ArgInt,
+					Required:     true,
+					Min:          router.Bound(0),
+					Max:          router.Bound(servsync.MaxPostingOdds),
+					Autocomplete: suggestNumbers(func(s servsync.Settings) uint64 { return uint64(s.PostingOdds) }, 0, 5, 10, 20, 50, 100),
+				},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: adjustRateCommand,
+		},
+		&router.Command{
+			Name:        "targetrate",
+			Description: "Aim for a number of messages an hour in each channel instead of fixed odds, 0 to go back",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{
+					Name:         "messages",
+					Description:  "Messages an hour",
+					Type:         router.ArgInt,
+					Required:     true,
+					Min:          router.Bound(0),
+					Max:          router.Bound(servsync.MaxTargetRate),
+					Autocomplete: suggestNumbers(func(s servsync.Settings) uint64 { return uint64(s.TargetRate) }, 0, 2, 4, 6, 12),
+				},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: targetRateCommand,
+		},
+		&router.Command{
+			Name:        "quiethours",
+			Description: "Hours the bot won't post on its own, the same start and end turns them off",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "from", Description: "Hour they start", Type: router.ArgInt, Required: true, Min: router.Bound(0), Max: router.Bound(23)},
+				{Name: "to", Description: "Hour they end", Type: router.ArgInt, Required: true, Min: router.Bound(0), Max: router.Bound(23)},
+				{Name: "timezone", Description: "Timezone like Europe/Dublin, defaults to the one already set or UTC"},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: quietHoursCommand,
+		},
+		&router.Command{
+			Name:        "setlength",
+			Description: "Change the most words the bot will say at once",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{
+					Name:         "words",
+					Description:  "Most words in a sentence",
+					Type:         router.ArgInt,
+					Required:     true,
+					Min:          router.Bound(1),
+					Max:          router.Bound(servsync.MaxSentence),
+					Autocomplete: suggestNumbers(func(s servsync.Settings) uint64 { return uint64(s.MaxLength) }, 10, 25, 50, 100),
+				},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: setLengthCommand,
+		},
+		&router.Command{
+			Name:        "feature",
+			Description: "Turn one of the bot's features on or off",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "feature", Description: "The feature", Choices: servsync.Features, Required: true},
+				{Name: "enabled", Description: "On or off", Type: router.ArgBool, Required: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: featureCommand,
+		},
+		&router.Command{
+			Name:        "channel",
+			Description: "Set whether a channel is learned from and/or talked in",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "mode", Description: "What to do there", Choices: []string{"learn", "reply", "both", "off"}, Required: true},
+				{Name: "channel", Description: "The channel, defaults to this one", Type: router.ArgChannel},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: channelCommand,
+		},
+		&router.Command{
+			Name:        "channelodds",
+			Description: "Chances out of 100 of replying in a channel, leave out to use the server's",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "rate", Description: "Chances out of 100", Type: router.ArgInt, Min: router.Bound(0), Max: router.Bound(servsync.MaxPostingOdds)},
+				{Name: "channel", Description: "The channel, defaults to this one", Type: router.ArgChannel},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: channelOddsCommand,
+		},
+		&router.Command{
+			Name:        "ignore",
+			Description: "Never learn from a channel",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "channel", Description: "The channel, defaults to this one", Type: router.ArgChannel},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: ignoreCommand,
+		},
+		&router.Command{
+			Name:        "unignore",
+			Description: "Stop ignoring a channel",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "channel", Description: "The channel, defaults to this one", Type: router.ArgChannel},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: unignoreCommand,
+		},
+		&router.Command{
+			Name:        "channels",
+			Description: "List the channels the bot learns from and talks in",
+			Checks:      []router.Check{needServer},
+			Handler:     channelsCommand,
+		},
+		&router.Command{
+			Name:        "settings",
+			Description: "Show this server's settings",
+			Checks:      []router.Check{needServer},
+			Handler:     settingsCommand,
+		},
+		&router.Command{
+			Name:        "resetsettings",
+			Description: "Go back to the default settings",
+			Permissions: manageServer,
+			Checks:      []router.Check{needServer},
+			Handler:     resetSettingsCommand,
+		},
+		&router.Command{
+			Name:        "prune",
+			Description: "Forget rarely seen words and phrases",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{
+					Name:         "threshold",
+					Description:  "Anything seen fewer times than this is forgotten",
+					Type:         router.ArgInt,
+					Min:          router.Bound(1),
+					Autocomplete: suggestNumbers(func(servsync.Settings) uint64 { return uint64(progFlags.PruneMin) }, 2, 3, 5, 10),
+				},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: pruneCommand,
+		},
+		&router.Command{
+			Name:        "reactions",
+			Description: "Set which reactions on my messages make me say more or less like that",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "kind", Description: "Good or bad reactions", Choices: []string{"good", "bad"}, Required: true},
+				{Name: "emoji", Description: "Emoji separated by spaces, none, or default", Required: true, Rest: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: reactionsCommand,
+		},
+		&router.Command{
+			Name:        "export",
+			Description: "Download everything the bot has learned in this server, along with its settings",
+			Permissions: manageServer,
+			Checks:      []router.Check{needServer},
+			Slow:        true,
+			Handler:     exportCommand,
+		},
+		&router.Command{
+			Name:        "import",
+			Description: "Load a zip from export, replacing what's here or merging with it",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "mode", Description: "Replace the chain and settings, or merge the chain in", Choices: []string{"replace", "merge"}, Required: true},
+				{Name: "archive", Description: "The zip from export", Type: router.ArgAttachment, Required: true},
+			},
+			Checks:  []router.Check{needServer},
+			Slow:    true,
+			Handler: importCommand,
+		},
+		&router.Command{
+			Name:        "schedule",
+			Description: "Post on a cron schedule or when a channel's been quiet for a while",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "mode", Description: "Cron schedule or idle time", Choices: []string{"cron", "idle"}, Required: true},
+				{Name: "channel", Description: "The channel to post in", Type: router.ArgChannel, Required: true},
+				{Name: "when", Description: "Like \"0 9 * * 1-5 Europe/Dublin\" for cron or 3h for idle", Required: true, Rest: true},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: scheduleCommand,
+		},
+		&router.Command{
+			Name:        "schedules",
+			Description: "List the server's schedules",
+			Permissions: manageServer,
+			Checks:      []router.Check{needServer},
+			Handler:     schedulesCommand,
+		},
+		&router.Command{
+			Name:        "unschedule",
+			Description: "Remove a schedule",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "id", Description: "The schedule's number from schedules", Type: router.ArgInt, Required: true, Min: router.Bound(1)},
+			},
+			Checks:  []router.Check{needServer},
+			Handler: unscheduleCommand,
+		},
+		&router.Command{
+			Name:        "adminroles",
+			Description: "Add, remove or list the roles that can run admin commands",
+			Permissions: manageServer,
+			Args: []*router.Arg{
+				{Name: "action", Description: "What to do", Choices: []string{"add", "remove", "list"}, Required: true},
+				{Name: "role", Description: "The role to add or remove", Type: router.ArgRole},
+			},
+			Checks:  []router.Check{needServer, needManageServer},
+			Handler: adminRolesCommand,
+		},
+	)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/ratelimit"
+	"github.com/danielh2942/markov_thingy/pkg/router"
+)
+
+// Cooldowns on replies and commands, so a busy channel or someone spamming !bark can't run away with things
+// Commands use their own rule if there is one, otherwise the "command" rule
+// Anything in the Cooldowns section of config.json replaces the default rule for that action
+
+const (
+	cooldownRandom  = "random"  // Random replies
+	cooldownMention = "mention" // Replies to being mentioned or replied to
+	cooldownCommand = "command" // Commands without their own rule
+	cooldownWarning = "warning" // Telling someone they've hit a limit, so that can't be spammed either
+)
+
+var defaultCooldowns = map[string]ratelimit.Rule{
+	cooldownRandom:  {Channel: ratelimit.Limit{Count: 1, Per: 30 * time.Second}},
+	cooldownMention: {Channel: ratelimit.Limit{Count: 10, Per: time.Minute}, User: ratelimit.Limit{Count: 4, Per: time.Minute}},
+	cooldownCommand: {User: ratelimit.Limit{Count: 10, Per: time.Minute}},
+	cooldownWarning: {User: ratelimit.Limit{Count: 1, Per: time.Minute}},
+	"bark":          {User: ratelimit.Limit{Count: 3, Per: 30 * time.Second}},
+	"ytrandom":      {Guild: ratelimit.Limit{Count: 20, Per: time.Hour}, User: ratelimit.Limit{Count: 2, Per: 5 * time.Minute}},
+	"impersonate":   {User: ratelimit.Limit{Count: 3, Per: time.Minute}},
+	"export":        {Guild: ratelimit.Limit{Count: 3, Per: time.Hour}},
+	"import":        {Guild: ratelimit.Limit{Count: 3, Per: time.Hour}},
+}
+
+var cooldowns = ratelimit.New(defaultCooldowns)
+
+// setupCooldowns puts the configured rules over the defaults
+func setupCooldowns(configured map[string]ratelimit.Rule) {
+	rules := map[string]ratelimit.Rule{}
+	for action, rule := range defaultCooldowns {
+		rules[action] = rule
+	}
+	for action, rule := range configured {
+		rules[action] = rule
+	}
+	cooldowns = ratelimit.New(rules)
+}
+
+// cooldownMessage politely tells someone what they hit and how long to wait
+func cooldownMessage(what string, res ratelimit.Result) string {
+	wait := max(res.Wait.Round(time.Second), time.Second)
+	switch res.Scope {
+	case ratelimit.ScopeGuild:
+		return fmt.Sprintf("sorry, the server's used %s a lot lately, give it %s", what, wait)
+	case ratelimit.ScopeChannel:
+		return fmt.Sprintf("sorry, this channel's used %s a lot lately, give it %s", what, wait)
+	}
+	return fmt.Sprintf("sorry, you're going a bit fast, you can use %s again in %s", what, wait)
+}
+
+// needCooldown is checked for every command
+func needCooldown(ctx *router.Context) error {
+	action := ctx.Command.Name
+	if _, ok := cooldowns.Rule(action); !ok {
+		action = cooldownCommand
+	}
+	res := cooldowns.Allow(action, ctx.GuildID, ctx.ChannelID, ctx.Author.ID)
+	if res.Allowed {
+		return nil
+	}
+	err := fmt.Errorf("%s", cooldownMessage(ctx.Prefix+ctx.Command.Name, res))
+	// Warning every time would just be a different way of spamming the channel
+	if !cooldowns.Allow(cooldownWarning, ctx.GuildID, ctx.ChannelID, ctx.Author.ID).Allowed {
+		return router.Quiet(err)
+	}
+	return err
+}
+
+// replyAllowed checks the cooldown for a reply, and lets whoever hit a mention limit know about it
+func replyAllowed(s *discordgo.Session, m *discordgo.MessageCreate, action string) bool {
+	res := cooldowns.Allow(action, m.GuildID, m.ChannelID, m.Author.ID)
+	if res.Allowed {
+		return true
+	}
+	if action == cooldownMention && cooldowns.Allow(cooldownWarning, m.GuildID, m.ChannelID, m.Author.ID).Allowed {
+		s.ChannelMessageSendReply(m.ChannelID, cooldownMessage("me", res), m.Reference())
+	}
+	return false
+}
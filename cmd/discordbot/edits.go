@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Keeping the chain in line with edited and deleted messages
+// Messages are found by ID if the chain tracked it, otherwise by what they said if that's still in the state's cache
+
+// learnable checks if a message is something that would have been learned from
+func learnable(s *discordgo.Session, msg *discordgo.Message) bool {
+	if msg.Author == nil || msg.Author.ID == BotId {
+		return false
+	}
+	if msg.Content == "" || isCommand(msg.Content) {
+		return false
+	}
+	serv, exists := myAuth.Servers.Get(msg.GuildID)
+	return exists && canLearn(s, serv, msg.ChannelID)
+}
+
+// isCommand checks if a message is a prefix command, with no prefix there are only slash commands
+func isCommand(content string) bool {
+	return myAuth.Prefix != "" && strings.HasPrefix(content, myAuth.Prefix)
+}
+
+// messageUpdate swaps the old version of an edited message for the new one
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	old := m.BeforeUpdate
+	if (old == nil && m.Content == "") || (old != nil && m.Content == old.Content) {
+		// An embed loading in
+		return
+	}
+	serv, exists := myAuth.Servers.Get(m.GuildID)
+	if !exists {
+		return
+	}
+	// Updates don't always include the author
+	updated := *m.Message
+	updated.GuildID = m.GuildID
+	if old != nil {
+		updated.Author = old.Author
+		old.GuildID = m.GuildID
+	}
+	// Without the cache the message ID is all there is to go on
+	var authorId, content string
+	if old != nil && learnable(s, old) {
+		authorId, content = old.Author.ID, old.Content
+	}
+	if old == nil || content != "" {
+		unlearned, err := serv.Unlearn(m.ID, authorId, content)
+		if err != nil {
+			logger.Println("Non-Fatal Error:", err.Error())
+		}
+		if old == nil && !unlearned {
+			// Never learned it, or can't tell
+			return
+		}
+	}
+	if learnable(s, &updated) {
+		serv.Learn(updated.ID, updated.Author.ID, updated.Content)
+	}
+}
+
+// messageDelete takes a deleted message back out of the chain
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	serv, exists := myAuth.Servers.Get(m.GuildID)
+	if !exists {
+		return
+	}
+	var authorId, content string
+	if old := m.BeforeDelete; old != nil {
+		old.GuildID = m.GuildID
+		if !learnable(s, old) {
+			return
+		}
+		authorId, content = old.Author.ID, old.Content
+	}
+	if _, err := serv.Unlearn(m.ID, authorId, content); err != nil {
+		logger.Println("Non-Fatal Error:", err.Error())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/servsync"
+)
+
+// Joining and leaving servers
+// Servers the bot is removed from are kept for progFlags.Retention in case it's added back, then archived or deleted
+
+// greetWithin is how recently the bot has to have joined for a GuildCreate to be a new server rather than a reconnect
+const greetWithin = 5 * time.Minute
+
+// archiveDir is where archived servers go
+const archiveDir = "archive"
+
+// guildCreate syncs slash commands for every server, and greets new ones or welcomes back old ones
+func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	if err := commandRouter.Sync(s, BotId, g.ID); err != nil {
+		logger.Println("Non-Fatal Error: Failed to register slash commands for guild", g.ID, err.Error())
+	} else {
+		logger.Println("Slash commands registered for guild", g.ID)
+	}
+	serv, exists := myAuth.Servers.Get(g.ID)
+	if exists {
+		if _, left := serv.LeftAt(); left {
+			serv.MarkActive()
+			saveSettings()
+			logger.Println("Added back to guild", g.ID, "picking up where it left off")
+		}
+		return
+	}
+	if time.Since(g.JoinedAt) > greetWithin {
+		return
+	}
+	logger.Println("Joined guild", g.ID)
+	channelId := greetingChannel(s, g.Guild)
+	if channelId == "" {
+		return
+	}
+	msg := "Hi! I learn how people here talk and chime in every now and then.\n" +
+		"To set me up, someone with Manage Server can run " + myAuth.Prefix + "lock (or /lock) in the channel I should learn from and talk in.\n" +
+		"After that, " + myAuth.Prefix + "help lists everything else, like adding more channels or changing how often I talk."
+	if _, err := s.ChannelMessageSend(channelId, msg); err != nil {
+		logger.Println("Non-Fatal Error: Couldn't greet guild", g.ID, err.Error())
+	}
+}
+
+// greetingChannel picks where to say hello, the system channel if there is one or the first text channel the bot can talk in
+func greetingChannel(s *discordgo.Session, g *discordgo.Guild) string {
+	if g.SystemChannelID != "" {
+		return g.SystemChannelID
+	}
+	for _, ch := range g.Channels {
+		if ch.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+		perms, err := s.State.UserChannelPermissions(BotId, ch.ID)
+		if err == nil && perms&discordgo.PermissionSendMessages != 0 {
+			return ch.ID
+		}
+	}
+	return ""
+}
+
+// guildDelete marks a server as left, unless it's only gone because of an outage
+func guildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
+	if g.Unavailable {
+		return
+	}
+	leaveGuild(g.ID)
+}
+
+// leaveGuild marks a server as left and stops anything running for it
+func leaveGuild(guildId string) {
+	serv, exists := myAuth.Servers.Get(guildId)
+	if !exists {
+		return
+	}
+	if _, left := serv.LeftAt(); left {
+		return
+	}
+	for _, job := range myAuth.Backfills.All() {
+		if job.GuildID == guildId {
+			stopBackfill(job.ChannelID)
+		}
+	}
+	serv.MarkLeft(time.Now())
+	saveSettings()
+	logger.Println("Removed from guild", guildId, "its data will be kept for", progFlags.Retention)
+}
+
+// ready catches servers the bot was removed from while it was offline
+func ready(s *discordgo.Session, r *discordgo.Ready) {
+	current := map[string]bool{}
+	for _, g := range r.Guilds {
+		current[g.ID] = true
+	}
+	myAuth.Servers.Range(func(guildID string, serv *servsync.ServSync) bool {
+		if !current[guildID] {
+			leaveGuild(guildID)
+		}
+		return true
+	})
+}
+
+// cleanupGuilds archives or deletes servers that were left longer ago than the retention period
+func cleanupGuilds() {
+	if progFlags.Retention <= 0 {
+		return
+	}
+	removed := false
+	myAuth.Servers.Range(func(guildID string, serv *servsync.ServSync) bool {
+		leftAt, left := serv.LeftAt()
+		if !left || time.Since(leftAt) < progFlags.Retention {
+			return true
+		}
+		if progFlags.RetentionMode == "delete" {
+			if err := serv.Delete(); err != nil {
+				logger.Println("Non-Fatal Error: Couldn't delete guild", guildID, err.Error())
+				return true
+			}
+			logger.Println("Deleted data for guild", guildID)
+		} else {
+			folder, err := serv.Archive(archiveDir, guildID)
+			if err != nil {
+				logger.Println("Non-Fatal Error: Couldn't archive guild", guildID, err.Error())
+				return true
+			}
+			logger.Println("Archived data for guild", guildID, "to", folder)
+		}
+		myAuth.Servers.Delete(guildID)
+		removed = true
+		return true
+	})
+	if removed {
+		// The config can't keep pointing at chain files that have gone
+		saveSettings()
+	}
+}
@@ -7,13 +7,17 @@ import (
 	"math/rand/v2"
 	"os"
 	"os/signal"
-	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Quiet hours can be in any timezone, even where the system doesn't have them
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/backfill"
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/ratelimit"
+	"github.com/danielh2942/markov_thingy/pkg/router"
 	"github.com/danielh2942/markov_thingy/pkg/servsync"
 	"github.com/danielh2942/markov_thingy/pkg/youtubesearch"
 )
@@ -23,13 +27,29 @@ type AuthStruct struct {
 	YoutubeAPIKey string           `json:"YoutubeAPIKey"` // Youtube Data Api Key token
 	Prefix        string           `json:"Prefix"`        // Command Prefix (TODO: Remove in favor of slash commands)
 	Servers       servsync.SyncMap `json:"Servers"`       // The servers that the program has access to
+	Backfills     backfill.Jobs    `json:"Backfills"`     // Backfills that haven't finished yet
+	// Cooldowns for replies and commands, replacing the defaults for any action listed
+	Cooldowns map[string]ratelimit.Rule `json:"Cooldowns,omitempty"`
 }
 
 type ProgramFlags struct {
-	Save        bool   // Save database incrementally
-	LogToFile   bool   // Write logs to a file (enforced form markov_bot_[date]_log.txt)
-	PostingOdds uint   // Odds out of 100 that it will reply
-	BackupFreq  uint64 // Save backup every n messages
+	Save           bool          // Save database incrementally
+	LogToFile      bool          // Write logs to a file (enforced form markov_bot_[date]_log.txt)
+	PostingOdds    uint          // Default odds out of 100 that it will reply
+	BackupFreq     uint64        // Default save backup every n messages
+	MaxLength      int           // Default most words in a generated sentence
+	TargetRate     uint          // Default bot messages an hour per channel, 0 for fixed odds
+	PruneEvery     time.Duration // Prune every chain this often (0 to never)
+	PruneMin       uint          // Edges seen fewer times than this get pruned
+	Decay          string        // How old messages are discounted, one of none, exp or window
+	HalfLife       time.Duration // Half-life used by exp decay
+	DecayWindow    time.Duration // Window length used by window decay
+	ImpersonateMix float64       // How much everyone else's messages count when impersonating someone
+	CacheMessages  int           // Messages kept per channel so edits and deletes can be unlearned
+	Typing         bool          // Default for showing typing and waiting before replying
+	Retention      time.Duration // How long to keep data for servers the bot was removed from (0 for forever)
+	RetentionMode  string        // What happens after that, archive or delete
+	Candidates     int           // Sentences generated per message, the most in character one is sent
 }
 
 func (pf ProgramFlags) String() string {
@@ -39,6 +59,11 @@ func (pf ProgramFlags) String() string {
 	output += "Save Logs as file:\t" + strconv.FormatBool(pf.LogToFile) + "\n"
 	output += "Response Frequency:\t" + strconv.FormatUint(uint64(pf.PostingOdds), 10) + "/100\n"
 	output += "Save Messages Every " + strconv.FormatUint(uint64(pf.BackupFreq), 10) + " Messages\n"
+	output += "Sentence Length:\t" + strconv.Itoa(pf.MaxLength) + " words\n"
+	output += "Prune Every:\t\t" + pf.PruneEvery.String() + " (below " + strconv.FormatUint(uint64(pf.PruneMin), 10) + ")\n"
+	output += "Decay:\t\t\t" + pf.Decay + " (half-life " + pf.HalfLife.String() + ", window " + pf.DecayWindow.String() + ")\n"
+	output += "Retention:\t\t" + pf.Retention.String() + " then " + pf.RetentionMode + "\n"
+	output += "Candidates:\t\t" + strconv.Itoa(pf.Candidates) + " per message\n"
 	return output
 }
 
@@ -46,20 +71,54 @@ func GetFlags() ProgramFlags {
 	progFlags := ProgramFlags{}
 
 	flag.BoolVar(&progFlags.Save, "nosave", true, "Don't save the data provided")
-	flag.UintVar(&progFlags.PostingOdds, "odds", 20, "Likelihood out of 100")
+	flag.UintVar(&progFlags.PostingOdds, "odds", 20, "Default likelihood out of 100, servers can change their own")
 	flag.BoolVar(&progFlags.LogToFile, "savelogs", false, "Log to a file")
-	flag.Uint64Var(&progFlags.BackupFreq, "backup", 100, "How many messages before a backup")
+	flag.Uint64Var(&progFlags.BackupFreq, "backup", 100, "Default number of messages before a backup, servers can change their own")
+	flag.UintVar(&progFlags.TargetRate, "targetrate", 0, "Default bot messages an hour to aim for per channel instead of fixed odds (0 for fixed odds), servers can change their own")
+	flag.IntVar(&progFlags.MaxLength, "length", 50, "Default most words in a generated sentence, servers can change their own")
+	flag.DurationVar(&progFlags.PruneEvery, "prune", 0, "How often to prune rare edges from every chain (0 to never)")
+	flag.UintVar(&progFlags.PruneMin, "prunemin", 2, "Edges seen fewer times than this are pruned")
+	flag.StringVar(&progFlags.Decay, "decay", "none", "How older messages are discounted: none, exp or window")
+	flag.DurationVar(&progFlags.HalfLife, "halflife", 7*24*time.Hour, "Half-life of an edge when using exp decay")
+	flag.DurationVar(&progFlags.DecayWindow, "decaywindow", 7*24*time.Hour, "Window length when using window decay")
+	flag.DurationVar(&progFlags.Retention, "retention", 30*24*time.Hour, "How long to keep data for servers the bot was removed from (0 to keep it forever)")
+	flag.StringVar(&progFlags.RetentionMode, "retentionmode", "archive", "What to do with a removed server's data after the retention period: archive or delete")
+	flag.IntVar(&progFlags.Candidates, "candidates", 1, "Sentences generated for each message the bot sends, the one that sounds most like the server is picked")
+	flag.BoolVar(&progFlags.Typing, "typing", false, "Show typing and take a human amount of time to reply by default, servers can change their own")
+	flag.IntVar(&progFlags.CacheMessages, "cachemessages", 500, "Messages remembered per channel so edits and deletes can be unlearned (0 to turn off)")
+	flag.Float64Var(&progFlags.ImpersonateMix, "impersonatemix", 0, "How much everyone else's messages count when impersonating a user (0 for only theirs)")
 
 	flag.Parse()
 
 	return progFlags
 }
 
+// applyDecay sets up a server's chain to use the decay model from the program flags
+func applyDecay(serv *servsync.ServSync) error {
+	decayer, ok := serv.MarkovChain.(markovcommon.Decayer)
+	if !ok {
+		return nil
+	}
+	mode, err := markovcommon.ParseDecayMode(progFlags.Decay)
+	if err != nil {
+		return err
+	}
+	return decayer.SetDecay(markovcommon.DecayConfig{
+		Mode:     mode,
+		HalfLife: progFlags.HalfLife,
+		Window:   progFlags.DecayWindow,
+	})
+}
+
 var (
-	progFlags             = GetFlags()
-	logger    *log.Logger = nil
-	file      *os.File    = nil
-	BotId     string
+	progFlags              = GetFlags()
+	logger     *log.Logger = nil
+	file       *os.File    = nil
+	BotId      string
+	myAuth     AuthStruct
+	ytListener *youtubesearch.YoutubeApiHandler
+	// Every command goes through here, prefix and slash alike
+	commandRouter *router.Router
 )
 
 func main() {
@@ -80,11 +139,22 @@ func main() {
 	logger.SetPrefix("[Markov Discord Bot] ")
 
 	logger.Println(progFlags)
+	if progFlags.RetentionMode != "archive" && progFlags.RetentionMode != "delete" {
+		logger.Fatalln("FATAL ERROR: -retentionmode has to be archive or delete")
+	}
 
 	if file != nil {
 		defer file.Close()
 	}
 
+	// The flags are only defaults, each server can change them for itself
+	// Anything without a flag, like the reaction emoji, keeps the package's default
+	servsync.Defaults.PostingOdds = progFlags.PostingOdds
+	servsync.Defaults.BackupFreq = progFlags.BackupFreq
+	servsync.Defaults.MaxLength = progFlags.MaxLength
+	servsync.Defaults.TargetRate = progFlags.TargetRate
+	servsync.Defaults.Features = map[string]bool{servsync.FeatureTyping: progFlags.Typing}
+
 	var err error
 	logger.Println("Reading in config file")
 	inpFile, err := os.ReadFile("config.json")
@@ -92,11 +162,19 @@ func main() {
 		logger.Fatalln("FATAL ERROR", err.Error())
 	}
 
-	var myAuth AuthStruct
 	err = json.Unmarshal(inpFile, &myAuth)
 	if err != nil {
 		logger.Fatalln("FATAL ERROR: Failed to read config.json. Reason:", err.Error())
 	}
+	myAuth.Servers.Range(func(guildID string, serv *servsync.ServSync) bool {
+		if err := applyDecay(serv); err != nil {
+			logger.Fatalln("FATAL ERROR: Failed to set up decay. Reason:", err.Error())
+		}
+		return true
+	})
+	setupCooldowns(myAuth.Cooldowns)
+	commandRouter = router.New(myAuth.Prefix, logger)
+	registerCommands(commandRouter)
 	discbot, err := discordgo.New("Bot " + myAuth.Token)
 	if err != nil {
 		logger.Fatalln(err.Error())
@@ -109,120 +187,26 @@ func main() {
 	}
 	BotId = u.ID
 	logger.Println("Setting up Youtube API stuff")
-	ytListener := youtubesearch.New(myAuth.YoutubeAPIKey, logger)
+	ytListener = youtubesearch.New(myAuth.YoutubeAPIKey, logger)
 	defer ytListener.Close()
 	logger.Println("Connecting general operation loop")
 	discbot.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
 		serv, exists := myAuth.Servers.Get(m.GuildID)
+		noteLastMessage(m.ChannelID)
 		if m.Author.ID == BotId {
 			return
 		}
-		if strings.HasPrefix(m.Content, myAuth.Prefix) {
-			if m.Message.Content == myAuth.Prefix+"bark" {
-				if serv == nil {
-					return
-				}
-				msg, err := serv.MarkovChain.GenerateSentence(50)
-				if err != nil {
-					logger.Println("Non-fatal Error:", err.Error())
-					return
-				}
-				s.ChannelMessageSend(serv.ChanId, msg)
-			}
-			if m.Message.Content == myAuth.Prefix+"lock" {
-				// limit to one channel
-				if !exists {
-					mc := servsync.New(m.ChannelID)
-					myAuth.Servers.Set(m.GuildID, mc)
-				} else {
-					serv.ChanId = m.ChannelID
-				}
-				outp, err := json.MarshalIndent(myAuth, "", "\t")
-				if err != nil {
-					return
-				}
-				outFile, err := os.Create("config.json")
-				if err != nil {
-					return
-				}
-				outFile.Write(outp)
-				outFile.Close()
-				logger.Println("Messages from guild", m.GuildID, "are now only read from channel with ID", m.ChannelID)
-				return
-			}
-			if m.Message.Content == myAuth.Prefix+"save" && progFlags.Save {
-				// force save - this is for debugging
-				if !exists {
-					return
-				}
-				if err := serv.Save(); err != nil {
-					logger.Println("Non-Fatal Error:", err.Error())
-				} else {
-					logger.Println("Saving checkpoint.")
-				}
-				return
-			}
-			if strings.HasPrefix(m.Message.Content, myAuth.Prefix+"setbackup") && progFlags.Save {
-				numFilter := regexp.MustCompile(`[0-9]+`)
-				val, err := strconv.Atoi(numFilter.FindString(m.Message.Content))
-				if err != nil {
-					logger.Println("Non-Fatal error:", err.Error())
-					return
-				}
-				progFlags.BackupFreq = uint64(val)
-				logger.Println("Backup frequency changed to every ", val, "Messages!")
-				return
-			}
-			if strings.HasPrefix(m.Message.Content, myAuth.Prefix+"adjustrate") {
-				numFilter := regexp.MustCompile(`[0-9]+`)
-				val, err := strconv.Atoi(numFilter.FindString(m.Message.Content))
-				if err != nil {
-					logger.Println("Non-Fatal error:", err.Error())
-					return
-				}
-				if val > 100 {
-					logger.Println("Invalid number entered for rate", val)
-					return
-				}
-				progFlags.PostingOdds = uint(val)
-			}
-			if !exists {
-				return
-			}
-			if m.Message.Content == myAuth.Prefix+"ytrandom" && m.ChannelID == serv.ChanId {
-				// Make sure that it always returns a video
-				for {
-					mq, err := serv.MarkovChain.GenerateSentence(20)
-					if err != nil {
-						logger.Println("Failed to generate Sentence, reason:", err.Error())
-						s.ChannelMessageSend(m.ChannelID, "An Error Occurred while generating a sentence!")
-						return
-					}
-					vid, err := ytListener.GetRandomVid(mq)
-					if err == nil {
-						s.ChannelMessageSend(m.ChannelID, "Video found with Query \""+mq+"\"\n"+vid)
-						break
-					}
-				}
-				return
-			}
-			if m.Message.Content == myAuth.Prefix+"help" && m.ChannelID == serv.ChanId {
-				s.ChannelMessageSend(
-					m.ChannelID,
-					"```"+myAuth.Prefix+"help\t\t\tShows this\n"+myAuth.Prefix+"ytrandom\t\tRandom Youtube Video from search query generated from input data\n"+myAuth.Prefix+"bark\t\t\tSay Something\n"+myAuth.Prefix+"adjustrate <value 0-100>\t\tChances out of 100 that the bot will say something```",
-				)
-				return
-			}
-		} else {
-			if !exists {
-				return
-			}
-			if m.ChannelID != serv.ChanId {
-				return
-			}
-			serv.MarkovChain.AddStringToData(m.Content)
+		if commandRouter.HandleMessage(s, m) {
+			return
+		}
+		if !exists {
+			return
+		}
+		settings := serv.Settings()
+		if learnable(s, m.Message) {
+			serv.Learn(m.ID, m.Author.ID, m.Content)
 			// save in bursts of n messages
-			if progFlags.Save && serv.MsgCount.Load() >= progFlags.BackupFreq {
+			if progFlags.Save && serv.MsgCount.Load() >= settings.BackupFreq {
 				if err := serv.Save(); err != nil {
 					logger.Println("Non-Fatal Error", err.Error())
 				} else {
@@ -230,31 +214,50 @@ func main() {
 				}
 				serv.MsgCount.Store(0)
 			}
-			// Reply when mentioned
-			if len(m.Mentions) > 0 {
-				for _, ment := range m.Mentions {
-					if ment.ID == BotId {
-						msg, err := serv.MarkovChain.GenerateSentence(50)
-						if err != nil {
-							logger.Println("Non-fatal ERROR:", err.Error())
-						}
-						s.ChannelMessageSendReply(m.ChannelID, msg, m.Reference())
-					}
-				}
-			} else if rand.IntN(100) < int(progFlags.PostingOdds) {
-				msg, err := serv.MarkovChain.GenerateSentence(50)
+			serv.MsgCount.Add(1)
+		}
+		if !canReply(s, serv, m.ChannelID) {
+			return
+		}
+		serv.NoteMessage(settingsChannel(s, serv, m.ChannelID))
+		words := markovcommon.ContentWords(m.Content)
+		// Reply when mentioned or replied to
+		if addressesBot(m) {
+			if settings.Enabled(servsync.FeatureMentions) && replyAllowed(s, m, cooldownMention) {
+				msg, path, err := generateReply(serv, m.ChannelID, words, settings.MaxLength)
 				if err != nil {
 					logger.Println("Non-fatal ERROR:", err.Error())
-					return
+				} else {
+					say(s, serv, settings, m.ChannelID, msg, path, m.Reference())
 				}
-				s.ChannelMessageSend(m.ChannelID, msg)
 			}
-			serv.MsgCount.Add(1)
+		} else if len(m.Mentions) == 0 && settings.Enabled(servsync.FeatureReplies) && rand.Float64() < replyChance(s, serv, settings, m.ChannelID) && replyAllowed(s, m, cooldownRandom) {
+			msg, path, err := generate(serv, nil, settings.MaxLength)
+			if err != nil {
+				logger.Println("Non-fatal ERROR:", err.Error())
+			} else {
+				say(s, serv, settings, m.ChannelID, msg, path, nil)
+			}
 		}
+		// Remembered after replying so the reply goes off this message first and earlier ones second
+		serv.RememberTopics(m.ChannelID, words)
 	})
 
-	// Only care about messages
-	discbot.Identify.Intents |= discordgo.IntentsGuildMessages
+	discbot.AddHandler(commandRouter.HandleInteraction)
+	discbot.AddHandler(threadCreate)
+	discbot.AddHandler(messageUpdate)
+	discbot.AddHandler(messageDelete)
+	discbot.AddHandler(reactionAdd)
+	discbot.AddHandler(reactionRemove)
+	// Edits and deletes only say what a message used to be if it's cached
+	discbot.State.MaxMessageCount = progFlags.CacheMessages
+	// This fires for every guild at startup and when joining a new one
+	discbot.AddHandler(guildCreate)
+	discbot.AddHandler(guildDelete)
+	discbot.AddHandler(ready)
+
+	// Only care about messages, reactions to them and guilds
+	discbot.Identify.Intents |= discordgo.IntentsGuildMessages | discordgo.IntentsGuilds | discordgo.IntentsGuildMessageReactions
 
 	logger.Println("Initalizing Discord Bot")
 
@@ -263,25 +266,46 @@ func main() {
 		logger.Fatalln("FATAL ERROR:", err.Error())
 	}
 	logger.Println("Bot Initalized")
+	resumeBackfills(discbot)
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			runSchedules(discbot)
+		}
+	}()
+	go func() {
+		for range time.Tick(time.Hour) {
+			cleanupGuilds()
+		}
+	}()
+
+	if progFlags.PruneEvery > 0 {
+		go func() {
+			for range time.Tick(progFlags.PruneEvery) {
+				myAuth.Servers.Range(func(guildID string, serv *servsync.ServSync) bool {
+					if stats, err := serv.Prune(progFlags.PruneMin); err != nil {
+						logger.Println("Non-Fatal Error:", err.Error())
+					} else {
+						logger.Println("Scheduled prune of guild", guildID, stats)
+					}
+					return true
+				})
+			}
+		}()
+	}
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	<-sc
 
 	// Cleanly close down the Discord session.
+	pauseBackfills()
 	discbot.Close()
 	// Save whatever the hell it had at the time of shutdown
 	logger.Println("Shutting down.")
 	if progFlags.Save {
-		outp, err := json.MarshalIndent(myAuth, "", "\t")
-		if err != nil {
-			return
-		}
-		outFile, err := os.Create("config.json")
-		if err != nil {
-			return
+		if err := saveAll(); err != nil {
+			logger.Println("Non-Fatal Error:", err.Error())
 		}
-		outFile.Write(outp)
-		outFile.Close()
 	}
 }
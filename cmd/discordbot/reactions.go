@@ -0,0 +1,114 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/servsync"
+)
+
+// Reactions on the bot's messages as feedback
+// Good reactions boost the edges that made the sentence a little, bad ones dampen them
+// Only recent messages count, the paths aren't saved so a restart forgets them
+
+const (
+	pathMemory = 1000           // Most sent messages remembered
+	pathExpiry = 24 * time.Hour // How long reactions on a message still count
+)
+
+type sentPath struct {
+	path    markovcommon.Path
+	at      time.Time
+	applied map[string][]markovcommon.StepChange // user and emoji -> what their reaction actually changed, so taking it off undoes exactly that
+}
+
+var (
+	pathMutex sync.Mutex
+	sentPaths = map[string]*sentPath{}
+	pathOrder []string // Message IDs oldest first, for dropping the oldest
+)
+
+// rememberPath keeps the path a sent message took, forgetting the oldest once there's too many
+func rememberPath(messageId string, path markovcommon.Path) {
+	pathMutex.Lock()
+	defer pathMutex.Unlock()
+	sentPaths[messageId] = &sentPath{path: path, at: time.Now(), applied: map[string][]markovcommon.StepChange{}}
+	pathOrder = append(pathOrder, messageId)
+	for len(pathOrder) > pathMemory || (len(pathOrder) > 0 && time.Since(sentPaths[pathOrder[0]].at) > pathExpiry) {
+		delete(sentPaths, pathOrder[0])
+		pathOrder = pathOrder[1:]
+	}
+}
+
+// customEmoji matches custom emoji as they're typed in messages, <:name:id> or <a:name:id>
+var customEmoji = regexp.MustCompile(`^<a?:(\w+:\d+)>$`)
+
+// emojiName gets how an emoji is stored in the settings, unicode emoji as they are and custom ones as name:id
+func emojiName(input string) string {
+	if match := customEmoji.FindStringSubmatch(input); match != nil {
+		return match[1]
+	}
+	return input
+}
+
+// reactionName is emojiName for an emoji from a reaction
+func reactionName(emoji discordgo.Emoji) string {
+	if emoji.ID != "" {
+		return emoji.Name + ":" + emoji.ID
+	}
+	return emoji.Name
+}
+
+// applyReaction does or undoes the feedback from one reaction
+func applyReaction(guildId string, messageId string, userId string, emoji discordgo.Emoji, added bool) {
+	if userId == BotId {
+		return
+	}
+	serv, exists := myAuth.Servers.Get(guildId)
+	if !exists {
+		return
+	}
+	reinforcer, ok := serv.MarkovChain.(markovcommon.Reinforcer)
+	if !ok {
+		return
+	}
+	settings := serv.Settings()
+	if !settings.Enabled(servsync.FeatureReactions) {
+		return
+	}
+
+	// The chain changes under the lock too so an add and its remove can't land out of order
+	pathMutex.Lock()
+	defer pathMutex.Unlock()
+	sent, ok := sentPaths[messageId]
+	if !ok || time.Since(sent.at) > pathExpiry {
+		return
+	}
+	key := userId + " " + reactionName(emoji)
+	if added {
+		if _, counted := sent.applied[key]; !counted {
+			if delta := settings.Reaction(reactionName(emoji)); delta != 0 {
+				sent.applied[key] = reinforcer.Reinforce(sent.path, delta)
+			}
+		}
+	} else if changes, counted := sent.applied[key]; counted {
+		reinforcer.Revert(changes)
+		delete(sent.applied, key)
+	}
+}
+
+// reactionAdd takes a reaction on one of the bot's messages as feedback
+func reactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.Member != nil && r.Member.User != nil && r.Member.User.Bot {
+		return
+	}
+	applyReaction(r.GuildID, r.MessageID, r.UserID, r.Emoji, true)
+}
+
+// reactionRemove undoes the feedback from a reaction that was taken off
+func reactionRemove(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
+	applyReaction(r.GuildID, r.MessageID, r.UserID, r.Emoji, false)
+}
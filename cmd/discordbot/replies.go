@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/servsync"
+)
+
+// Replying to people who talk to the bot, either by mentioning it or replying to one of its messages
+
+// addressesBot checks if a message mentions the bot or replies to one of its messages
+func addressesBot(m *discordgo.MessageCreate) bool {
+	if ref := m.ReferencedMessage; ref != nil && ref.Author != nil && ref.Author.ID == BotId {
+		return true
+	}
+	for _, ment := range m.Mentions {
+		if ment.ID == BotId {
+			return true
+		}
+	}
+	return false
+}
+
+// generateReply tries to say something about the words given, then what the channel's been talking about, then anything
+func generateReply(serv *servsync.ServSync, channelId string, words []string, limit int) (string, markovcommon.Path, error) {
+	for _, seeds := range [][]string{words, serv.Topics(channelId)} {
+		if len(seeds) == 0 {
+			continue
+		}
+		if msg, path, err := generate(serv, seeds, limit); err == nil {
+			return msg, path, nil
+		}
+	}
+	return generate(serv, nil, limit)
+}
+
+// generate makes progFlags.Candidates sentences and sends back the most in character one
+// Chains that can't score sentences only get one go
+func generate(serv *servsync.ServSync, seeds []string, limit int) (string, markovcommon.Path, error) {
+	scorer, ok := serv.MarkovChain.(markovcommon.Scorer)
+	if !ok || progFlags.Candidates <= 1 {
+		return generateOnce(serv, seeds, limit)
+	}
+	msgs := []string{}
+	paths := []markovcommon.Path{}
+	for i := 0; i < progFlags.Candidates; i++ {
+		msg, path, err := generateOnce(serv, seeds, limit)
+		if err != nil {
+			return "", nil, err
+		}
+		msgs = append(msgs, msg)
+		paths = append(paths, path)
+	}
+	best := max(markovcommon.MostLikely(scorer, msgs), 0)
+	return msgs[best], paths[best], nil
+}
+
+// generateOnce makes a sentence starting from one of the seeds, or anywhere without them
+// The path is only there if the chain can take feedback on it
+func generateOnce(serv *servsync.ServSync, seeds []string, limit int) (string, markovcommon.Path, error) {
+	if reinforcer, ok := serv.MarkovChain.(markovcommon.Reinforcer); ok {
+		return reinforcer.GenerateSentencePath(seeds, limit)
+	}
+	if len(seeds) == 0 {
+		msg, err := serv.MarkovChain.GenerateSentence(limit)
+		return msg, nil, err
+	}
+	if seeder, ok := serv.MarkovChain.(markovcommon.Seeder); ok {
+		msg, err := seeder.GenerateSentenceFrom(seeds, limit)
+		return msg, nil, err
+	}
+	return "", nil, markovcommon.ErrNoSeed
+}
+
+// replyChance gets the chance from 0 to 1 of randomly replying in a channel right now
+// Quiet hours are never, a target rate adapts to how busy the channel is, otherwise it's the channel's odds
+func replyChance(s *discordgo.Session, serv *servsync.ServSync, settings servsync.Settings, channelId string) float64 {
+	if settings.QuietHours.Contains(time.Now()) {
+		return 0
+	}
+	if settings.TargetRate > 0 {
+		return serv.AdaptiveOdds(settingsChannel(s, serv, channelId), settings.TargetRate)
+	}
+	return float64(channelOdds(s, serv, channelId)) / 100
+}
+
+// How long the bot "types" for when the typing feature is on
+const (
+	typingBase    = 800 * time.Millisecond // Reading the message and thinking of something
+	typingPerChar = 40 * time.Millisecond
+	typingMax     = 9 * time.Second // Typing indicators last 10 seconds, so one is always enough
+	typingJitter  = 0.3             // Delays are up to this fraction longer or shorter
+)
+
+// typingDelay works out how long it'd take a person to type a message
+func typingDelay(msg string) time.Duration {
+	delay := typingBase + time.Duration(len(msg))*typingPerChar
+	jitter := 1 + typingJitter*(2*rand.Float64()-1)
+	return min(time.Duration(float64(delay)*jitter), typingMax)
+}
+
+// say sends a generated message, as a reply if ref isn't nil, and remembers the path for reactions
+// With typing on it shows the typing indicator and waits first, off in its own goroutine so nothing else is held up
+func say(s *discordgo.Session, serv *servsync.ServSync, settings servsync.Settings, channelId string, msg string, path markovcommon.Path, ref *discordgo.MessageReference) {
+	send := func() {
+		var sent *discordgo.Message
+		var err error
+		if ref != nil {
+			sent, err = s.ChannelMessageSendReply(channelId, msg, ref)
+		} else {
+			sent, err = s.ChannelMessageSend(channelId, msg)
+		}
+		if err != nil {
+			logger.Println("Non-Fatal Error:", err.Error())
+			return
+		}
+		serv.NotePost(settingsChannel(s, serv, channelId))
+		if path != nil {
+			rememberPath(sent.ID, path)
+		}
+	}
+	if !settings.Enabled(servsync.FeatureTyping) {
+		send()
+		return
+	}
+	go func() {
+		if err := s.ChannelTyping(channelId); err != nil {
+			logger.Println("Non-Fatal Error:", err.Error())
+		}
+		time.Sleep(typingDelay(msg))
+		send()
+	}()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/schedule"
+	"github.com/danielh2942/markov_thingy/pkg/servsync"
+)
+
+// Posting on a schedule or when a channel goes quiet
+// The schedules live in each server's config, this just checks them every minute
+
+// lastMessages is when something was last said in each channel since startup, the bot included
+var lastMessages sync.Map
+
+// noteLastMessage records that something was just said in a channel
+func noteLastMessage(channelId string) {
+	lastMessages.Store(channelId, time.Now())
+}
+
+// lastActivity gets when something was last said in a channel
+// Before anything's been said since startup it goes off the channel's last message ID
+func lastActivity(s *discordgo.Session) func(string) time.Time {
+	return func(channelId string) time.Time {
+		if last, ok := lastMessages.Load(channelId); ok {
+			return last.(time.Time)
+		}
+		ch, err := s.State.Channel(channelId)
+		if err != nil {
+			if ch, err = s.Channel(channelId); err != nil {
+				// Can't tell, so don't post
+				return time.Now()
+			}
+		}
+		if ch.LastMessageID == "" {
+			return time.Time{}
+		}
+		last, err := discordgo.SnowflakeTimestamp(ch.LastMessageID)
+		if err != nil {
+			return time.Now()
+		}
+		return last
+	}
+}
+
+// runSchedules posts for every schedule that's due
+func runSchedules(s *discordgo.Session) {
+	now := time.Now()
+	changed := false
+	myAuth.Servers.Range(func(guildID string, serv *servsync.ServSync) bool {
+		settings := serv.Settings()
+		if _, left := serv.LeftAt(); left || !settings.Enabled(servsync.FeatureSchedules) {
+			return true
+		}
+		due := serv.DueSchedules(now, lastActivity(s))
+		for _, sch := range due {
+			// Idle posts count as speaking up on their own, cron ones were asked for at that time
+			if sch.Cron == "" && settings.QuietHours.Contains(now) {
+				continue
+			}
+			if !canReply(s, serv, sch.ChannelID) {
+				continue
+			}
+			msg, path, err := generateReply(serv, sch.ChannelID, nil, settings.MaxLength)
+			if err != nil {
+				logger.Println("Non-fatal ERROR:", err.Error())
+				continue
+			}
+			say(s, serv, settings, sch.ChannelID, msg, path, nil)
+		}
+		changed = changed || len(due) > 0
+		return true
+	})
+	if changed {
+		// Once for every server, so they don't post again after a restart
+		saveSettings()
+	}
+}
+
+// parseWhen reads a schedule, either an idle time like 3h or a cron expression with an optional timezone on the end
+func parseWhen(mode string, channelId string, when string, timezone string) (*schedule.Schedule, error) {
+	if mode == "idle" {
+		idle, err := time.ParseDuration(strings.TrimSpace(when))
+		if err != nil {
+			return nil, errBadDuration
+		}
+		return schedule.NewIdle(channelId, idle)
+	}
+	fields := strings.Fields(when)
+	if len(fields) == 6 || (len(fields) == 2 && strings.HasPrefix(fields[0], "@")) {
+		timezone = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	return schedule.NewCron(channelId, strings.Join(fields, " "), timezone)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/danielh2942/markov_thingy/pkg/servsync"
+)
+
+// Thread and forum post support
+// Threads don't have settings of their own, they use whatever the channel they're in has
+
+// settingsChannel works out whose channel settings apply, threads use their parent's when threads are turned on
+func settingsChannel(s *discordgo.Session, serv *servsync.ServSync, channelId string) string {
+	if !serv.Settings().Enabled(servsync.FeatureThreads) {
+		return channelId
+	}
+	ch, err := s.State.Channel(channelId)
+	if err != nil {
+		// Threads the bot hasn't seen yet aren't in the state
+		if ch, err = s.Channel(channelId); err != nil {
+			logger.Println("Non-Fatal Error: Failed to look up channel", channelId, err.Error())
+			return channelId
+		}
+		s.State.ChannelAdd(ch)
+	}
+	if ch.IsThread() {
+		return ch.ParentID
+	}
+	return channelId
+}
+
+// canLearn checks if messages in a channel or thread should be learned from
+func canLearn(s *discordgo.Session, serv *servsync.ServSync, channelId string) bool {
+	return !serv.IsIgnored(channelId) && serv.CanLearn(settingsChannel(s, serv, channelId))
+}
+
+// canReply checks if the bot can talk in a channel or thread
+func canReply(s *discordgo.Session, serv *servsync.ServSync, channelId string) bool {
+	return serv.CanReply(settingsChannel(s, serv, channelId))
+}
+
+// channelOdds gets the reply odds for a channel or thread
+func channelOdds(s *discordgo.Session, serv *servsync.ServSync, channelId string) uint {
+	return serv.Odds(settingsChannel(s, serv, channelId))
+}
+
+// threadCreate joins new threads in channels the bot learns from or talks in
+func threadCreate(s *discordgo.Session, t *discordgo.ThreadCreate) {
+	if !t.NewlyCreated {
+		return
+	}
+	serv, exists := myAuth.Servers.Get(t.GuildID)
+	if !exists {
+		return
+	}
+	settings := serv.Settings()
+	if !settings.Enabled(servsync.FeatureThreads) || !settings.Enabled(servsync.FeatureJoinThreads) {
+		return
+	}
+	if serv.IsIgnored(t.ID) || (!serv.CanLearn(t.ParentID) && !serv.CanReply(t.ParentID)) {
+		return
+	}
+	if err := s.ThreadJoin(t.ID); err != nil {
+		logger.Println("Non-Fatal Error: Failed to join thread", t.ID, err.Error())
+		return
+	}
+	logger.Println("Joined thread", t.ID, "in guild", t.GuildID)
+}
@@ -0,0 +1,269 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Backfill
+// Author Daniel Hannon
+// Version 1
+// Brief: Pages back through a channel's history so a new chain has something to start with
+// Jobs keep track of where they got to so they can be saved and picked back up after a restart
+// Rate limits are left to discordgo, it waits out 429s and the rate limit headers on its own
+
+var (
+	ErrAlreadyRunning = errors.New("a backfill is already running in that channel")
+	ErrNotRunning     = errors.New("there's no backfill running in that channel")
+)
+
+// PageSize is how many messages are asked for at once, 100 is the most discord allows
+const PageSize = 100
+
+type Job struct {
+	GuildID         string
+	ChannelID       string    // Channel being backfilled
+	Limit           int       // Most messages to learn, 0 for no limit
+	Cutoff          time.Time // Stop at messages older than this, zero for no cutoff
+	Before          string    // Oldest message looked at so far, the next page starts from here
+	Seen            int       // Messages looked at
+	Learned         int       // Messages actually learned from
+	Done            bool      // Finished, either by running out of history or hitting the limit/cutoff
+	ReportChannelID string    // Where progress gets reported
+	ReportMessageID string    // Progress message that gets edited as the job goes
+	mutex           sync.Mutex
+}
+
+// jobJSON is the same as Job without the mutex
+type jobJSON struct {
+	GuildID         string
+	ChannelID       string
+	Limit           int       `json:",omitempty"`
+	Cutoff          time.Time `json:",omitempty"`
+	Before          string    `json:",omitempty"`
+	Seen            int
+	Learned         int
+	Done            bool
+	ReportChannelID string `json:",omitempty"`
+	ReportMessageID string `json:",omitempty"`
+}
+
+// LearnFunc learns from a message, returning false if it was skipped (bots, commands, etc.)
+type LearnFunc func(msg *discordgo.Message) bool
+
+// ProgressFunc gets called after every page, this is where the job and the chain should be saved
+type ProgressFunc func(job *Job)
+
+// New creates a job for a channel
+func New(guildId string, channelId string, limit int, cutoff time.Time) *Job {
+	return &Job{
+		GuildID:   guildId,
+		ChannelID: channelId,
+		Limit:     limit,
+		Cutoff:    cutoff,
+	}
+}
+
+// Progress gets how far along the job is
+func (j *Job) Progress() (seen int, learned int, done bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.Seen, j.Learned, j.Done
+}
+
+// SetReport sets the message progress gets reported in
+func (j *Job) SetReport(channelId string, messageId string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.ReportChannelID = channelId
+	j.ReportMessageID = messageId
+}
+
+// Report gets the message progress gets reported in
+func (j *Job) Report() (channelId string, messageId string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.ReportChannelID, j.ReportMessageID
+}
+
+func (j *Job) String() string {
+	seen, learned, done := j.Progress()
+	output := "Learned " + strconv.Itoa(learned) + " of " + strconv.Itoa(seen) + " messages"
+	if j.Limit > 0 {
+		output += " (limit " + strconv.Itoa(j.Limit) + ")"
+	}
+	if !j.Cutoff.IsZero() {
+		output += " back to " + j.Cutoff.Format(time.DateOnly)
+	}
+	if done {
+		return "Backfill finished. " + output
+	}
+	return "Backfilling... " + output
+}
+
+// step handles one message, returning true once the job is finished
+func (j *Job) step(msg *discordgo.Message, learn LearnFunc) bool {
+	if !j.Cutoff.IsZero() && msg.Timestamp.Before(j.Cutoff) {
+		return true
+	}
+	learned := learn(msg)
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.Before = msg.ID
+	j.Seen++
+	if learned {
+		j.Learned++
+	}
+	return j.Limit > 0 && j.Learned >= j.Limit
+}
+
+// finish marks the job as done
+func (j *Job) finish() {
+	j.mutex.Lock()
+	j.Done = true
+	j.mutex.Unlock()
+}
+
+// Run pages back through the channel until the job is done, the context is cancelled or a request fails
+// It carries on from wherever the job got to, so a job that returned an error can just be run again
+func (j *Job) Run(ctx context.Context, s *discordgo.Session, learn LearnFunc, progress ProgressFunc) error {
+	for {
+		if _, _, done := j.Progress(); done {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		j.mutex.Lock()
+		before := j.Before
+		j.mutex.Unlock()
+		msgs, err := s.ChannelMessages(j.ChannelID, PageSize, before, "", "", discordgo.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		// Messages come back newest first
+		finished := len(msgs) == 0
+		for _, msg := range msgs {
+			if finished = j.step(msg, learn); finished {
+				break
+			}
+		}
+		if finished {
+			j.finish()
+		}
+		if progress != nil {
+			progress(j)
+		}
+	}
+}
+
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return json.Marshal(&jobJSON{
+		GuildID:         j.GuildID,
+		ChannelID:       j.ChannelID,
+		Limit:           j.Limit,
+		Cutoff:          j.Cutoff,
+		Before:          j.Before,
+		Seen:            j.Seen,
+		Learned:         j.Learned,
+		Done:            j.Done,
+		ReportChannelID: j.ReportChannelID,
+		ReportMessageID: j.ReportMessageID,
+	})
+}
+
+func (j *Job) UnmarshalJSON(data []byte) error {
+	aux := &jobJSON{}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.GuildID = aux.GuildID
+	j.ChannelID = aux.ChannelID
+	j.Limit = aux.Limit
+	j.Cutoff = aux.Cutoff
+	j.Before = aux.Before
+	j.Seen = aux.Seen
+	j.Learned = aux.Learned
+	j.Done = aux.Done
+	j.ReportChannelID = aux.ReportChannelID
+	j.ReportMessageID = aux.ReportMessageID
+	return nil
+}
+
+// Jobs is the list of unfinished jobs, it's safe to use from multiple goroutines
+type Jobs struct {
+	jobs  []*Job
+	mutex sync.RWMutex
+}
+
+// Add adds a job, only one job can run per channel
+func (js *Jobs) Add(job *Job) error {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	if slices.ContainsFunc(js.jobs, func(v *Job) bool { return v.ChannelID == job.ChannelID }) {
+		return ErrAlreadyRunning
+	}
+	js.jobs = append(js.jobs, job)
+	return nil
+}
+
+// Get gets the job for a channel
+func (js *Jobs) Get(channelId string) (*Job, bool) {
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+	idx := slices.IndexFunc(js.jobs, func(v *Job) bool { return v.ChannelID == channelId })
+	if idx < 0 {
+		return nil, false
+	}
+	return js.jobs[idx], true
+}
+
+// Remove removes the job for a channel
+func (js *Jobs) Remove(channelId string) error {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	before := len(js.jobs)
+	js.jobs = slices.DeleteFunc(js.jobs, func(v *Job) bool { return v.ChannelID == channelId })
+	if len(js.jobs) == before {
+		return ErrNotRunning
+	}
+	return nil
+}
+
+// All lists every job
+func (js *Jobs) All() []*Job {
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+	return slices.Clone(js.jobs)
+}
+
+func (js *Jobs) MarshalJSON() ([]byte, error) {
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+	if js.jobs == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(js.jobs)
+}
+
+func (js *Jobs) UnmarshalJSON(data []byte) error {
+	jobs := []*Job{}
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return err
+	}
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	js.jobs = jobs
+	return nil
+}
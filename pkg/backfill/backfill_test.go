@@ -0,0 +1,162 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeDiscord serves a channel with count messages, IDs 1 to count, one a minute starting at baseTime
+// Every 10th message is from a bot and the first request gets rate limited
+func fakeDiscord(t *testing.T, count int) (*discordgo.Session, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	limited := &atomic.Bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/channels/123/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		if !limited.Swap(true) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.01, "global": false}`))
+			return
+		}
+		requests.Add(1)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		newest := count
+		if before := r.URL.Query().Get("before"); before != "" {
+			val, _ := strconv.Atoi(before)
+			newest = val - 1
+		}
+		msgs := []*discordgo.Message{}
+		for id := newest; id > 0 && len(msgs) < limit; id-- {
+			msgs = append(msgs, &discordgo.Message{
+				ID:        strconv.Itoa(id),
+				ChannelID: "123",
+				Content:   "message " + strconv.Itoa(id),
+				Timestamp: baseTime.Add(time.Duration(id) * time.Minute),
+				Author:    &discordgo.User{ID: "456", Bot: id%10 == 0},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msgs)
+	}))
+	t.Cleanup(srv.Close)
+
+	oldEndpoint := discordgo.EndpointChannels
+	discordgo.EndpointChannels = srv.URL + "/channels/"
+	t.Cleanup(func() { discordgo.EndpointChannels = oldEndpoint })
+
+	s, err := discordgo.New("Bot token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, requests
+}
+
+// learner records what gets learned, skipping bots
+func learner(learned map[string]int) LearnFunc {
+	return func(msg *discordgo.Message) bool {
+		if msg.Author.Bot {
+			return false
+		}
+		learned[msg.ID]++
+		return true
+	}
+}
+
+func TestBackfillWholeChannel(t *testing.T) {
+	s, requests := fakeDiscord(t, 250)
+	learned := map[string]int{}
+	pages := 0
+	job := New("1", "123", 0, time.Time{})
+	if err := job.Run(context.Background(), s, learner(learned), func(*Job) { pages++ }); err != nil {
+		t.Fatal(err)
+	}
+	seen, count, done := job.Progress()
+	if !done || seen != 250 || count != 225 || len(learned) != 225 {
+		t.Fatal("Expected 225 of 250 learned, got", count, "of", seen, "done", done)
+	}
+	// 3 pages of messages and an empty one to find the end
+	if requests.Load() != 4 || pages != 4 {
+		t.Fatal("Expected 4 requests and progress reports, got", requests.Load(), pages)
+	}
+}
+
+func TestBackfillLimitAndCutoff(t *testing.T) {
+	s, _ := fakeDiscord(t, 250)
+	learned := map[string]int{}
+	job := New("1", "123", 50, time.Time{})
+	if err := job.Run(context.Background(), s, learner(learned), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, count, done := job.Progress(); !done || count != 50 || learned["249"] != 1 || learned["195"] != 1 || learned["194"] != 0 {
+		t.Fatal("Expected the newest 50 non-bot messages, got", count, learned)
+	}
+
+	learned = map[string]int{}
+	job = New("1", "123", 0, baseTime.Add(150*time.Minute))
+	if err := job.Run(context.Background(), s, learner(learned), nil); err != nil {
+		t.Fatal(err)
+	}
+	if seen, _, done := job.Progress(); !done || seen != 101 || learned["151"] != 1 || learned["149"] != 0 {
+		t.Fatal("Expected messages back to 150, saw", seen)
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	s, _ := fakeDiscord(t, 250)
+	learned := map[string]int{}
+	jobs := &Jobs{}
+	if err := jobs.Add(New("1", "123", 0, time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.Add(New("1", "123", 0, time.Time{})); err != ErrAlreadyRunning {
+		t.Fatal("Expected a second job in the channel to fail, got", err)
+	}
+	job, _ := jobs.Get("123")
+
+	// Stop after the first page, like the bot being shut down
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := job.Run(ctx, s, learner(learned), func(*Job) { cancel() }); err != context.Canceled {
+		t.Fatal("Expected the job to be cancelled, got", err)
+	}
+
+	saved, err := json.Marshal(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &Jobs{}
+	if err := json.Unmarshal(saved, restored); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := restored.Get("123")
+	if !ok || job.Before != "151" || job.Seen != 100 {
+		t.Fatal("Job not restored properly:", string(saved))
+	}
+
+	if err := job.Run(context.Background(), s, learner(learned), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, count, done := job.Progress(); !done || count != 225 || len(learned) != 225 {
+		t.Fatal("Expected 225 learned after resuming, got", count)
+	}
+	for id, times := range learned {
+		if times != 1 {
+			t.Fatal("Message", id, "learned", times, "times")
+		}
+	}
+	if restored.Remove("123") != nil || restored.Remove("123") != ErrNotRunning {
+		t.Fatal("Removing a job twice should only work once")
+	}
+}
@@ -0,0 +1,132 @@
+package markovcommon
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+)
+
+// authors.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Optional tracking of who taught the chain what, so sentences can be generated in someone's style
+
+var ErrUnknownAuthor = errors.New("nothing has been learned from that author")
+
+// AuthorTracker is implemented by chains that can keep track of who added what
+type AuthorTracker interface {
+	AddStringFromAuthor(author string, input string) error
+	ForgetAuthor(author string)
+	GenerateSentenceAs(author string, limit int, mix float64) (string, error)
+}
+
+// hashID squashes an ID down to something smaller to store
+func hashID(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+// AddStringFromAuthor works like AddStringToData but also records the edges against the author
+func (md *MarkovData) AddStringFromAuthor(author string, input string) error {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	edges, err := md.addString(input)
+	if err != nil || author == "" {
+		return err
+	}
+	md.attributeEdges(author, edges)
+	return nil
+}
+
+// attributeEdges records edges against an author, the caller must hold the write lock
+func (md *MarkovData) attributeEdges(author string, edges []edge) {
+	key := hashID(author)
+	for _, e := range edges {
+		md.attributeEdge(key, e.From, e.To, 1)
+	}
+}
+
+// attributeEdge adds count to one edge in a hashed author's graph, the caller must hold the write lock
+func (md *MarkovData) attributeEdge(key uint64, from uint, to uint, count uint) {
+	if md.AuthorGraph == nil {
+		md.AuthorGraph = map[uint64]map[uint]map[uint]uint{}
+	}
+	if md.AuthorGraph[key] == nil {
+		md.AuthorGraph[key] = map[uint]map[uint]uint{}
+	}
+	graph := md.AuthorGraph[key]
+	if graph[from] == nil {
+		graph[from] = map[uint]uint{}
+	}
+	graph[from][to] += count
+}
+
+// ForgetAuthor drops everything tracked against an author
+// What they added to the chain itself stays put
+func (md *MarkovData) ForgetAuthor(author string) {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	delete(md.AuthorGraph, hashID(author))
+}
+
+// authorPick does a weighted pick where the author's own edges count in full and everyone else's are scaled by mix
+func (md *MarkovData) authorPick(graph map[uint]map[uint]uint, wordNo uint, mix float64) (uint, bool) {
+	weights := map[uint]float64{}
+	total := 0.0
+	for k, v := range graph[wordNo] {
+		weights[k] += float64(v)
+		total += float64(v)
+	}
+	if mix > 0 {
+		for k, v := range md.WordGraph[wordNo] {
+			weights[k] += float64(v) * mix
+			total += float64(v) * mix
+		}
+	}
+	if total <= 0 {
+		return 0, false
+	}
+
+	choice := rand.Float64() * total
+	var last uint
+	for k, weight := range weights {
+		choice -= weight
+		last = k
+		if choice < 0 {
+			break
+		}
+	}
+	return last, true
+}
+
+// GenerateSentenceAs produces a sentence using the edges learned from one author
+// mix is how much everyone else's edges count compared to theirs, 0 sticks to only what they've said
+func (md *MarkovData) GenerateSentenceAs(author string, limit int, mix float64) (string, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	graph, ok := md.AuthorGraph[hashID(author)]
+	if !ok {
+		return "", ErrUnknownAuthor
+	}
+	start, ok := md.WordRef["§"]
+	if !ok {
+		return "", errors.New("no data in markov database")
+	}
+	currWord, ok := md.authorPick(graph, start, mix)
+	if !ok {
+		return "", ErrUnknownAuthor
+	}
+
+	output := md.WordVals[currWord]
+	for x := 0; x < limit; x++ {
+		nextWord, ok := md.authorPick(graph, currWord, mix)
+		if !ok || strings.Contains(".!?", md.WordVals[currWord]) {
+			break
+		}
+		output += " " + md.WordVals[nextWord]
+		currWord = nextWord
+	}
+	return output, nil
+}
@@ -1,7 +1,6 @@
 package markovcommon
 
 import (
-	"encoding/json"
 	"errors"
 	"os"
 	"slices"
@@ -33,6 +32,7 @@ func checkvalidpath(filename string) bool {
 }
 
 // ReadinFile loads a previously saved database file, deserializes it, and returns a struct matching the MarkovChain interface
+// Files saved by older versions are upgraded as they're read in, so this always gives back a *MarkovData
 func ReadinFile(filepath string) (MarkovChain, error) {
 	if len(filepath) == 0 || filepath == "" {
 		return &MarkovData{}, errors.New("no filename passed, doing nothing")
@@ -44,14 +44,76 @@ func ReadinFile(filepath string) (MarkovChain, error) {
 	if err != nil {
 		return &MarkovData{}, err
 	}
-	var outp MarkovData
-	err1 := json.Unmarshal(data, &outp)
-	if err1 == nil {
-		return &outp, nil
+	return decodeData(data)
+}
+
+var ErrCorrupt = errors.New("database is corrupt")
+
+// Decode reads a chain from Encode or a saved file, upgrading old versions
+// Unlike ReadinFile it checks every word reference is in range, so it's safe for files from somewhere else
+func Decode(data []byte) (*MarkovData, error) {
+	md, err := decodeData(data)
+	if err != nil {
+		return nil, err
+	}
+	md.initialise()
+	count := uint(len(md.WordVals))
+	if md.WordCount != count || uint(len(md.WordGraph)) != count || uint(len(md.WordRef)) != count {
+		return nil, ErrCorrupt
+	}
+	for ref, word := range md.WordVals {
+		if md.WordRef[word] != uint(ref) {
+			return nil, ErrCorrupt
+		}
+	}
+	for from, edges := range md.WordGraph {
+		if edges == nil {
+			md.WordGraph[from] = map[uint]uint{}
+		}
+		for to := range edges {
+			if to >= count {
+				return nil, ErrCorrupt
+			}
+		}
+	}
+	for _, start := range md.StartWords {
+		if start >= count {
+			return nil, ErrCorrupt
+		}
+	}
+	if uint(len(md.EdgeAges)) > count {
+		return nil, ErrCorrupt
+	}
+	for from, ages := range md.EdgeAges {
+		if ages == nil {
+			md.EdgeAges[from] = map[uint]EdgeAge{}
+		}
+		for to := range ages {
+			if to >= count {
+				return nil, ErrCorrupt
+			}
+		}
+	}
+	for _, graph := range md.AuthorGraph {
+		for from, edges := range graph {
+			for to := range edges {
+				if from >= count || to >= count {
+					return nil, ErrCorrupt
+				}
+			}
+		}
+	}
+	for _, record := range md.Messages {
+		if record == nil || len(record.Edges)%2 != 0 {
+			return nil, ErrCorrupt
+		}
+		for _, ref := range record.Edges {
+			if ref >= count {
+				return nil, ErrCorrupt
+			}
+		}
 	}
-	var outp1 MarkovDataOld
-	err1 = json.Unmarshal(data, &outp1)
-	return &outp1, err1
+	return md, nil
 }
 
 func checkhonorific(inp string) bool {
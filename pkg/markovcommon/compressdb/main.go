@@ -1,14 +1,16 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"os"
 
 	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
 )
 
+// compressdb
+// Upgrades a database saved by an older version to the current format.
+// ReadinFile does this on load anyway, this just saves the result.
+
 func main() {
 	var input string
 	var output string
@@ -22,53 +24,13 @@ func main() {
 		return
 	}
 
-	content, err := os.ReadFile(input)
+	data, err := markovcommon.ReadinFile(input)
 	if err != nil {
 		fmt.Println("Error occurred", err.Error())
-	}
-
-	var InputData markovcommon.MarkovDataOld
-	// Create Empty compressed version
-	OutputData := markovcommon.MarkovData{}
-
-	if err := json.Unmarshal(content, &InputData); err != nil {
-		fmt.Println("Parsing error occurred", err.Error())
 		return
 	}
 
-	// The actual data transformation step
-	// Step 1 create the List of WordRef and WordVals
-	OutputData.WordCount = 0
-	OutputData.WordRef = map[string]uint{}
-	OutputData.WordVals = []string{}
-	for k := range InputData.Wordmaps {
-		if _, ok := OutputData.WordRef[k]; !ok {
-			OutputData.WordRef[k] = OutputData.WordCount
-			OutputData.WordVals = append(OutputData.WordVals, k)
-			OutputData.WordCount++
-		}
+	if err := data.SaveToFile(output); err != nil {
+		fmt.Println("Error occurred while saving", err.Error())
 	}
-	OutputData.WordRef["."] = OutputData.WordCount
-	OutputData.WordVals = append(OutputData.WordVals, ".")
-	OutputData.WordCount++
-
-	OutputData.WordGraph = []map[uint]uint{}
-	// Now the actual mappings
-	for idx, val := range OutputData.WordVals {
-		OutputData.WordGraph = append(OutputData.WordGraph, map[uint]uint{})
-		for k, v := range InputData.Wordmaps[val] {
-			if k == "\\end" {
-				OutputData.WordGraph[idx][OutputData.WordRef["."]] = uint(v)
-			} else {
-				OutputData.WordGraph[idx][OutputData.WordRef[k]] = uint(v)
-			}
-		}
-	}
-
-	OutputData.StartWords = []uint{}
-	for _, v := range InputData.Startwords {
-		OutputData.StartWords = append(OutputData.StartWords, OutputData.WordRef[v])
-	}
-
-	OutputData.SaveToFile(output)
 }
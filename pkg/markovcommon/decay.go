@@ -0,0 +1,211 @@
+package markovcommon
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// decay.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Discounts edges by how long ago they were seen so the chain keeps up with the conversation
+// Nothing gets recalculated when data is added, the age of each edge is only looked at when picking words
+
+// timeNow is swapped out by the tests
+var timeNow = time.Now
+
+type DecayMode int
+
+const (
+	DecayNone        DecayMode = iota // Lifetime counts, the default
+	DecayExponential                  // Every HalfLife an edge counts for half as much
+	DecayWindow                       // Only the current and previous Window count, the previous one fading out
+)
+
+func (dm DecayMode) String() string {
+	switch dm {
+	case DecayExponential:
+		return "exp"
+	case DecayWindow:
+		return "window"
+	default:
+		return "none"
+	}
+}
+
+// ParseDecayMode is the reverse of DecayMode.String
+func ParseDecayMode(mode string) (DecayMode, error) {
+	switch mode {
+	case "none", "":
+		return DecayNone, nil
+	case "exp":
+		return DecayExponential, nil
+	case "window":
+		return DecayWindow, nil
+	}
+	return DecayNone, errors.New("unknown decay mode " + mode)
+}
+
+// DecayConfig controls how edges are discounted by age
+// Changing HalfLife or Window only affects edges from then on, ages already recorded are left as they are
+type DecayConfig struct {
+	Mode     DecayMode     `json:"Mode"`     // Which of the models below to use
+	HalfLife time.Duration `json:"HalfLife"` // Used by DecayExponential
+	Window   time.Duration `json:"Window"`   // Used by DecayWindow
+}
+
+// EdgeAge holds what's needed to work out an edge's weight at any point in time
+type EdgeAge struct {
+	Decayed float64 `json:"d"` // Count with exponential decay applied, as of Last
+	Last    int64   `json:"l"` // Unix time the edge was last seen
+	Window  uint    `json:"w"` // Times seen in the window Last falls in
+	Prev    uint    `json:"p"` // Times seen in the window before that
+}
+
+// Decayer is implemented by chains that support decaying edges
+type Decayer interface {
+	SetDecay(DecayConfig) error
+}
+
+// SetDecay changes the decay model used when generating sentences
+// Ages are only recorded while decay is on, edges without one fall back to their lifetime count
+func (md *MarkovData) SetDecay(cfg DecayConfig) error {
+	if cfg.Mode == DecayExponential && cfg.HalfLife <= 0 {
+		return errors.New("exponential decay needs a half-life above 0")
+	}
+	if cfg.Mode == DecayWindow && cfg.Window <= 0 {
+		return errors.New("windowed decay needs a window above 0")
+	}
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	md.Decay = cfg
+	return nil
+}
+
+// windowIndex works out which window a unix timestamp falls in
+func windowIndex(unix int64, window time.Duration) int64 {
+	return unix / int64(window.Seconds())
+}
+
+// touchEdge records that an edge was just seen
+func (md *MarkovData) touchEdge(from uint, to uint) {
+	if md.Decay.Mode == DecayNone {
+		return
+	}
+	for uint(len(md.EdgeAges)) < md.WordCount {
+		md.EdgeAges = append(md.EdgeAges, map[uint]EdgeAge{})
+	}
+	now := timeNow()
+	age := md.EdgeAges[from][to]
+	if md.Decay.HalfLife > 0 {
+		age.Decayed = age.decayed(now, md.Decay.HalfLife) + 1
+	}
+	if md.Decay.Window >= time.Second {
+		switch windowIndex(now.Unix(), md.Decay.Window) - windowIndex(age.Last, md.Decay.Window) {
+		case 0:
+			age.Window++
+		case 1:
+			age.Prev = age.Window
+			age.Window = 1
+		default:
+			age.Prev = 0
+			age.Window = 1
+		}
+	}
+	age.Last = now.Unix()
+	md.EdgeAges[from][to] = age
+}
+
+// scale multiplies every count in the age by ratio, for when part of an edge is taken away
+func (ea EdgeAge) scale(ratio float64) EdgeAge {
+	ea.Decayed *= ratio
+	ea.Window = uint(math.Round(float64(ea.Window) * ratio))
+	ea.Prev = uint(math.Round(float64(ea.Prev) * ratio))
+	return ea
+}
+
+// decayed is the exponentially decayed count of an edge at a point in time
+func (ea EdgeAge) decayed(now time.Time, halfLife time.Duration) float64 {
+	elapsed := float64(now.Unix() - ea.Last)
+	return ea.Decayed * math.Exp2(-elapsed/halfLife.Seconds())
+}
+
+// windowed is the count of an edge over a sliding window ending at a point in time
+// The previous window is faded out as the current one fills up
+func (ea EdgeAge) windowed(now time.Time, window time.Duration) float64 {
+	if window < time.Second {
+		return 0
+	}
+	current := windowIndex(now.Unix(), window)
+	progress := float64(now.Unix()%int64(window.Seconds())) / window.Seconds()
+	switch current - windowIndex(ea.Last, window) {
+	case 0:
+		return float64(ea.Window) + float64(ea.Prev)*(1-progress)
+	case 1:
+		return float64(ea.Window) * (1 - progress)
+	}
+	return 0
+}
+
+// decayedPick does a weighted pick using the decayed weights of each edge
+// It returns false when none of the edges have been seen recently enough to count
+func (md *MarkovData) decayedPick(wordNo uint) (uint, bool) {
+	if md.Decay.Mode == DecayNone || wordNo >= uint(len(md.EdgeAges)) {
+		return 0, false
+	}
+	now := timeNow()
+	ages := md.EdgeAges[wordNo]
+	keys := make([]uint, 0, len(ages))
+	weights := make([]float64, 0, len(ages))
+	total := 0.0
+	for k, age := range ages {
+		if _, ok := md.WordGraph[wordNo][k]; !ok {
+			continue
+		}
+		var weight float64
+		if md.Decay.Mode == DecayExponential {
+			weight = age.decayed(now, md.Decay.HalfLife)
+		} else {
+			weight = age.windowed(now, md.Decay.Window)
+		}
+		if weight <= 0 {
+			continue
+		}
+		keys = append(keys, k)
+		weights = append(weights, weight)
+		total += weight
+	}
+	if total <= 0 {
+		return 0, false
+	}
+
+	choice := rand.Float64() * total
+	for idx, weight := range weights {
+		choice -= weight
+		if choice < 0 {
+			return keys[idx], true
+		}
+	}
+	return keys[len(keys)-1], true
+}
+
+// pickNext picks the word to follow wordNo, favouring recent edges when decay is on
+func (md *MarkovData) pickNext(wordNo uint) uint {
+	if next, ok := md.decayedPick(wordNo); ok {
+		return next
+	}
+	return md.weightedPick(wordNo)
+}
+
+// pickStart picks a word to start a sentence with
+// With decay on the start words people have used recently are more likely
+func (md *MarkovData) pickStart() uint {
+	if start, ok := md.WordRef["§"]; ok {
+		if next, ok := md.decayedPick(start); ok {
+			return next
+		}
+	}
+	return md.StartWords[rand.IntN(len(md.StartWords))]
+}
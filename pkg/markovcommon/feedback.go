@@ -0,0 +1,131 @@
+package markovcommon
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+)
+
+// feedback.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Remembering how a sentence was made so people's reactions to it can nudge the chain
+
+// Step is one edge walked while generating, by word so it still makes sense after a prune renumbers things
+type Step struct {
+	From string
+	To   string
+}
+
+// Path is every step a generated sentence took
+type Path []Step
+
+// StepChange is how much Reinforce actually moved one step's edge, which can be less than asked for
+type StepChange struct {
+	Step
+	Delta int
+}
+
+// Reinforcer is implemented by chains that can say how they made a sentence and take feedback on it
+type Reinforcer interface {
+	GenerateSentencePath(seeds []string, limit int) (string, Path, error)
+	Reinforce(path Path, delta int) []StepChange
+	Revert(changes []StepChange)
+}
+
+// GenerateSentencePath works like GenerateSentenceFrom, or GenerateSentence without seeds, but also gives back the path taken
+func (md *MarkovData) GenerateSentencePath(seeds []string, limit int) (string, Path, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	if len(seeds) == 0 {
+		if md.WordCount == 0 || len(md.StartWords) == 0 {
+			return "", nil, errors.New("no data in markov database")
+		}
+		start := md.pickStart()
+		output, edges := md.generatePath(start, limit)
+		if ref, ok := md.WordRef["§"]; ok {
+			edges = append([]edge{{ref, start}}, edges...)
+		}
+		return output, md.toPath(edges), nil
+	}
+	candidates := md.seedWords(seeds)
+	if len(candidates) == 0 {
+		return "", nil, ErrNoSeed
+	}
+	output, edges := md.generatePath(candidates[rand.IntN(len(candidates))], limit)
+	return output, md.toPath(edges), nil
+}
+
+// toPath turns edges into words, the caller must hold the read lock
+func (md *MarkovData) toPath(edges []edge) Path {
+	output := make(Path, 0, len(edges))
+	for _, e := range edges {
+		output = append(output, Step{md.WordVals[e.From], md.WordVals[e.To]})
+	}
+	return output
+}
+
+// Reinforce adds delta to the count of every edge on a path, or takes it away if it's negative
+// Edges never go below 1 this way, that's what unlearning is for, and ones the chain no longer has are skipped
+// With decay on the edge's age moves too, damping scales it down and boosting counts as seeing the edge again
+// It returns what actually changed for every step that changed, for Revert
+func (md *MarkovData) Reinforce(path Path, delta int) []StepChange {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	output := []StepChange{}
+	for _, step := range path {
+		if changed := md.adjustStep(step, delta); changed != 0 {
+			output = append(output, StepChange{step, changed})
+		}
+	}
+	return output
+}
+
+// Revert undoes changes from Reinforce, as far as the edges are still there to undo
+func (md *MarkovData) Revert(changes []StepChange) {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	for _, change := range changes {
+		md.adjustStep(change.Step, -change.Delta)
+	}
+}
+
+// adjustStep moves one step's edge by delta without going below 1 and returns how far it really moved
+// The caller must hold the write lock
+func (md *MarkovData) adjustStep(step Step, delta int) int {
+	from, ok := md.WordRef[step.From]
+	if !ok {
+		return 0
+	}
+	to, ok := md.WordRef[step.To]
+	if !ok {
+		return 0
+	}
+	count := md.WordGraph[from][to]
+	switch {
+	case count == 0:
+		return 0
+	case delta > 0:
+		for i := 0; i < delta; i++ {
+			md.incrementEdge(from, to)
+		}
+		return delta
+	case delta < 0 && count > 1:
+		amount := min(uint(-delta), count-1)
+		md.decrementEdge(from, to, amount)
+		return -int(amount)
+	}
+	return 0
+}
+
+// String writes the path out like a sentence, mostly for logs
+func (p Path) String() string {
+	words := []string{}
+	for idx, step := range p {
+		if idx == 0 && step.From != "§" {
+			words = append(words, step.From)
+		}
+		words = append(words, step.To)
+	}
+	return strings.Join(words, " ")
+}
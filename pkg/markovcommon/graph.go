@@ -0,0 +1,204 @@
+package markovcommon
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+)
+
+// graph.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Poking around the word graph, what comes after a word, what comes before it and how to get between two words
+// Probabilities here use lifetime counts, decay only changes what gets generated
+
+var (
+	ErrUnknownWord = errors.New("that word hasn't been learned")
+	ErrNoPath      = errors.New("there's no way to get between those words")
+)
+
+// Explorer is implemented by chains that can be queried word by word
+type Explorer interface {
+	Next(word string, top int) ([]Neighbour, error)
+	Prev(word string, top int) ([]Neighbour, error)
+	Path(from string, to string) ([]string, float64, error)
+}
+
+// Neighbour is a word next to another one and how likely it is to be there
+type Neighbour struct {
+	Word        string
+	Count       uint
+	Probability float64
+}
+
+// noteEdge keeps the reverse index up to date when an edge is added, the caller must hold the write lock
+// Removed edges are left in the index, lookups check the edge is still there
+func (md *MarkovData) noteEdge(from uint, to uint) {
+	if md.reverse == nil {
+		return
+	}
+	for uint(len(md.reverse)) <= to {
+		md.reverse = append(md.reverse, map[uint]struct{}{})
+	}
+	md.reverse[to][from] = struct{}{}
+}
+
+// predecessors gets every word with an edge into word, building the reverse index the first time
+// The caller must hold the read lock
+func (md *MarkovData) predecessors(word uint) []uint {
+	md.reverseMutex.Lock()
+	defer md.reverseMutex.Unlock()
+	if md.reverse == nil {
+		md.reverse = make([]map[uint]struct{}, len(md.WordGraph))
+		for idx := range md.reverse {
+			md.reverse[idx] = map[uint]struct{}{}
+		}
+		for from, edges := range md.WordGraph {
+			for to := range edges {
+				md.reverse[to][uint(from)] = struct{}{}
+			}
+		}
+	}
+	output := []uint{}
+	if word >= uint(len(md.reverse)) {
+		return output
+	}
+	for from := range md.reverse[word] {
+		if md.WordGraph[from][word] > 0 {
+			output = append(output, from)
+		}
+	}
+	return output
+}
+
+// outgoing is the total count of every edge leaving a word, the caller must hold the read lock
+func (md *MarkovData) outgoing(word uint) uint {
+	total := uint(0)
+	for _, count := range md.WordGraph[word] {
+		total += count
+	}
+	return total
+}
+
+// topNeighbours sorts by count, ties alphabetically, and keeps the first top
+func topNeighbours(output []Neighbour, top int) []Neighbour {
+	slices.SortFunc(output, func(a, b Neighbour) int {
+		if a.Count != b.Count {
+			return int(b.Count) - int(a.Count)
+		}
+		return strings.Compare(a.Word, b.Word)
+	})
+	return output[:min(top, len(output))]
+}
+
+// Next gets the words most likely to follow word, with the chance of each
+func (md *MarkovData) Next(word string, top int) ([]Neighbour, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	ref, ok := md.WordRef[word]
+	if !ok {
+		return nil, ErrUnknownWord
+	}
+	total := md.outgoing(ref)
+	output := make([]Neighbour, 0, len(md.WordGraph[ref]))
+	for to, count := range md.WordGraph[ref] {
+		output = append(output, Neighbour{md.WordVals[to], count, float64(count) / float64(total)})
+	}
+	return topNeighbours(output, top), nil
+}
+
+// Prev gets the words that most often come before word
+// The chance is out of every time word was said, so they add up to 1 like Next
+func (md *MarkovData) Prev(word string, top int) ([]Neighbour, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	ref, ok := md.WordRef[word]
+	if !ok {
+		return nil, ErrUnknownWord
+	}
+	total := uint(0)
+	output := []Neighbour{}
+	for _, from := range md.predecessors(ref) {
+		count := md.WordGraph[from][ref]
+		total += count
+		output = append(output, Neighbour{Word: md.WordVals[from], Count: count})
+	}
+	for idx := range output {
+		output[idx].Probability = float64(output[idx].Count) / float64(total)
+	}
+	return topNeighbours(output, top), nil
+}
+
+// pathItem is a word waiting to be visited in Path
+type pathItem struct {
+	word uint
+	cost float64
+}
+
+type pathQueue []pathItem
+
+func (pq pathQueue) Len() int            { return len(pq) }
+func (pq pathQueue) Less(i, j int) bool  { return pq[i].cost < pq[j].cost }
+func (pq pathQueue) Swap(i, j int)       { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *pathQueue) Push(x interface{}) { *pq = append(*pq, x.(pathItem)) }
+func (pq *pathQueue) Pop() interface{} {
+	old := *pq
+	item := old[len(old)-1]
+	*pq = old[:len(old)-1]
+	return item
+}
+
+// Path finds the most likely run of words from one word to another, including both, and the chance of the chain walking it
+// Every step costs -log of its probability so the cheapest path is the most likely one
+func (md *MarkovData) Path(from string, to string) ([]string, float64, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	start, ok := md.WordRef[from]
+	if !ok {
+		return nil, 0, ErrUnknownWord
+	}
+	end, ok := md.WordRef[to]
+	if !ok {
+		return nil, 0, ErrUnknownWord
+	}
+	if start == end {
+		return []string{from}, 1, nil
+	}
+
+	cost := map[uint]float64{start: 0}
+	previous := map[uint]uint{}
+	done := map[uint]bool{}
+	queue := &pathQueue{{start, 0}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathItem)
+		if done[item.word] {
+			continue
+		}
+		done[item.word] = true
+		if item.word == end {
+			break
+		}
+		total := float64(md.outgoing(item.word))
+		for next, count := range md.WordGraph[item.word] {
+			nextCost := item.cost - math.Log(float64(count)/total)
+			if old, seen := cost[next]; !done[next] && (!seen || nextCost < old) {
+				cost[next] = nextCost
+				previous[next] = item.word
+				heap.Push(queue, pathItem{next, nextCost})
+			}
+		}
+	}
+	if !done[end] {
+		return nil, 0, ErrNoPath
+	}
+
+	output := []string{to}
+	for word := end; word != start; {
+		word = previous[word]
+		output = append(output, md.WordVals[word])
+	}
+	slices.Reverse(output)
+	return output, math.Exp(-cost[end]), nil
+}
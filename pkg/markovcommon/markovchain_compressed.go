@@ -7,8 +7,8 @@ import (
 	"os"
 	"path"
 	"regexp"
-	"strings"
 	"slices"
+	"strings"
 	"sync"
 )
 
@@ -18,12 +18,20 @@ import (
 // Brief: This is like MarkovDataOld but it uses some compression shit innit
 
 type MarkovData struct {
-	StartWords []uint          `json:"StartWords"` // Numeric references to each start word
-	WordCount  uint            `json:"WordCount"`  // Number of words available
-	WordRef    map[string]uint `json:"WordMap"`    // Word to number mappings
-	WordVals   []string        `json:"WordVals"`   // Number to word mappings
-	WordGraph  []map[uint]uint `json:"WordGraph"`  // Mappings of word number -> word number with frequency of relationship
-	mutex      sync.RWMutex    // Mutexes for locks and shit
+	StartWords  []uint                            `json:"StartWords"`            // Numeric references to each start word
+	WordCount   uint                              `json:"WordCount"`             // Number of words available
+	WordRef     map[string]uint                   `json:"WordMap"`               // Word to number mappings
+	WordVals    []string                          `json:"WordVals"`              // Number to word mappings
+	WordGraph   []map[uint]uint                   `json:"WordGraph"`             // Mappings of word number -> word number with frequency of relationship
+	Decay       DecayConfig                       `json:"Decay"`                 // How edges are discounted by age when generating
+	EdgeAges    []map[uint]EdgeAge                `json:"EdgeAges,omitempty"`    // Same layout as WordGraph, how long ago each edge was seen
+	AuthorGraph map[uint64]map[uint]map[uint]uint `json:"AuthorGraph,omitempty"` // Hashed author ID -> word number -> word number -> frequency, only for authors that are tracked
+	Messages    map[uint64]*MessageRecord         `json:"Messages,omitempty"`    // Hashed message ID -> what it added, see provenance.go
+	Learned     uint                              `json:"Learned,omitempty"`     // How many strings have been added, for stats
+	mutex       sync.RWMutex                      // Mutexes for locks and shit
+
+	reverse      []map[uint]struct{} // Word number -> words with an edge into it, built when first needed, see graph.go
+	reverseMutex sync.Mutex          // Readers share the read lock so building the reverse index needs its own
 }
 
 // getWordRef checks if a word exists and returns it's numeric equivalent, otherwise it makes one :)
@@ -39,15 +47,8 @@ func (md *MarkovData) getWordRef(word string) uint {
 	return temp
 }
 
-// AddStringToData gets a string and parses it into a format that is interpretable by the MarkovData struct
-func (md *MarkovData) AddStringToData(input string) error {
-	md.mutex.Lock()
-	defer md.mutex.Unlock()
-	if input == "" {
-		return errors.New("nothing passed, nothing to do")
-	}
-
-	// Initialization checks
+// initialise makes sure none of the maps/slices are nil before data gets added
+func (md *MarkovData) initialise() {
 	// This makes sure nothing fucks up
 	if md.StartWords == nil {
 		md.StartWords = []uint{}
@@ -62,74 +63,109 @@ func (md *MarkovData) AddStringToData(input string) error {
 	if md.WordGraph == nil {
 		md.WordGraph = []map[uint]uint{}
 	}
+}
 
-	// Some Sanitization for reasons
-
+// Filters used to tidy up input before it gets split into tokens
+var (
 	// Filter out illegal characters
-	generalPuncuationFilter := regexp.MustCompile(
+	generalPuncuationFilter = regexp.MustCompile(
 		`[^&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}\-.\:\/\\!,.<>@_*?=']`,
 	)
+	exclaimFilter   = regexp.MustCompile(`[^&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+[!]+`)
+	exclaimFilter1  = regexp.MustCompile(`[!]+`)
+	questionFilter  = regexp.MustCompile(`[^&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+[?]+`)
+	questionFilter1 = regexp.MustCompile(`[?]+`)
+	commaFilter     = regexp.MustCompile(`[&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+,`)
+	commaFilter1    = regexp.MustCompile(`[,]+`)
+	fullStopFilter  = regexp.MustCompile(`[&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+\.\s`)
+	fullStopFilter1 = regexp.MustCompile(`\.\s`)
+)
+
+// tokenize sanitizes a string and splits it up into words and punctuation
+func tokenize(input string) []string {
 	input = generalPuncuationFilter.ReplaceAllString(input, " ")
 
 	// Separate exclamations
-	exclaimFilter := regexp.MustCompile(`[^&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+[!]+`)
 	input = exclaimFilter.ReplaceAllStringFunc(input, func(inp string) string {
-		exclaimFilter1 := regexp.MustCompile(`[!]+`)
 		return exclaimFilter1.ReplaceAllString(inp, " ! ")
 	})
 
 	// Question Marks
-	questionFilter := regexp.MustCompile(`[^&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+[?]+`)
 	input = questionFilter.ReplaceAllStringFunc(input, func(inp string) string {
-		questionFilter1 := regexp.MustCompile(`[?]+`)
 		return questionFilter1.ReplaceAllString(inp, " ? ")
 	})
 
 	// Separate commas
-	commaFilter := regexp.MustCompile(`[&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+,`)
 	input = commaFilter.ReplaceAllStringFunc(input, func(inp string) string {
-		commaFilter1 := regexp.MustCompile(`[,]+`)
 		return commaFilter1.ReplaceAllString(inp, " , ")
 	})
 
 	// Separate Full Stops
-	fullStopFilter := regexp.MustCompile(`[&#a-zA-Z0-9\p{Arabic}\p{Cyrillic}\x{1F000}-\x{1FFFF}\x{2600}-\x{26FF}]+\.\s`)
 	input = fullStopFilter.ReplaceAllStringFunc(input, func(inp string) string {
 		if checkhonorific(inp) {
 			return inp
 		}
-		fullStopFilter1 := regexp.MustCompile(`\.\s`)
 		return fullStopFilter1.ReplaceAllString(inp, " . ")
 	})
 
-	// Split input into tokens
-	wordArr := strings.Split(input, " ")
+	output := []string{}
+	for _, word := range strings.Split(input, " ") {
+		if len(word) != 0 {
+			output = append(output, word)
+		}
+	}
+	return output
+}
+
+// edge is a single step from one word to another
+type edge struct {
+	From uint
+	To   uint
+}
+
+// parseEdges turns a string into the list of edges it adds to the chain, making new words as it goes
+func (md *MarkovData) parseEdges(input string) []edge {
+	return walkEdges(input, func(word string) (uint, bool) { return md.getWordRef(word), true })
+}
+
+// findEdges is parseEdges without making new words, edges with words the chain doesn't know are left out
+func (md *MarkovData) findEdges(input string) []edge {
+	return walkEdges(input, func(word string) (uint, bool) {
+		val, ok := md.WordRef[word]
+		return val, ok
+	})
+}
+
+// walkEdges splits a string into the edges between its words, ref looks up the number for each word
+// "§" denotes Start words, so any edge coming from it is a start word
+func walkEdges(input string, ref func(string) (uint, bool)) []edge {
+	output := []edge{}
 	startOfSentence := true
 	var previousWord uint
-
-	// Insert the data as appropriate
-	for _, word := range wordArr {
-		if len(word) == 0 {
-			continue
+	var previousOk bool
+	var previousToken string
+	add := func(fromOk bool, from uint, word string) (uint, bool) {
+		to, ok := ref(word)
+		if fromOk && ok {
+			output = append(output, edge{from, to})
 		}
+		return to, ok
+	}
+
+	for _, word := range tokenize(input) {
 		if startOfSentence {
-			if strings.ContainsAny(word,",.!?") {
+			if strings.ContainsAny(word, ",.!?") {
 				continue
 			}
 
 			startOfSentence = false
-			val := md.getWordRef(word)
-			if !slices.Contains(md.StartWords, val) {
-				md.StartWords = append(md.StartWords, val)
-			}
-			// "§" denotes Start words, currently getting rid of md.StartWords
-			md.WordGraph[md.getWordRef("§")][val]++
-			previousWord = val
+			start, startOk := ref("§")
+			previousWord, previousOk = add(startOk, start, word)
+			previousToken = word
 			continue
 		}
-		currWord := md.getWordRef(word)
-		md.WordGraph[previousWord][currWord]++
-		previousWord = currWord
+		previousWord, previousOk = add(previousOk, previousWord, word)
+		previousToken = word
 
 		// Check stopwords
 		if strings.Contains(".!", word) {
@@ -138,12 +174,47 @@ func (md *MarkovData) AddStringToData(input string) error {
 	}
 
 	// Don't add data to stop words, no point.
-	if previousWord == md.getWordRef(".") || previousWord == md.getWordRef("!") || previousWord == md.getWordRef("?") {
-		return nil
-	} else {
-		md.WordGraph[previousWord][md.getWordRef(".")]++
+	if previousToken != "" && previousToken != "." && previousToken != "!" && previousToken != "?" {
+		add(previousOk, previousWord, ".")
+	}
+	return output
+}
+
+// AddStringToData gets a string and parses it into a format that is interpretable by the MarkovData struct
+func (md *MarkovData) AddStringToData(input string) error {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	_, err := md.addString(input)
+	return err
+}
+
+// addString does the work for AddStringToData and hands back the edges it added
+// The caller must hold the write lock
+func (md *MarkovData) addString(input string) ([]edge, error) {
+	if input == "" {
+		return nil, errors.New("nothing passed, nothing to do")
+	}
+
+	md.initialise()
+
+	// Insert the data as appropriate
+	start := md.getWordRef("§")
+	edges := md.parseEdges(input)
+	for _, e := range edges {
+		if e.From == start && !slices.Contains(md.StartWords, e.To) {
+			md.StartWords = append(md.StartWords, e.To)
+		}
+		md.incrementEdge(e.From, e.To)
 	}
-	return nil
+	md.Learned++
+	return edges, nil
+}
+
+// incrementEdge bumps the count of an edge by one
+func (md *MarkovData) incrementEdge(from uint, to uint) {
+	md.WordGraph[from][to]++
+	md.touchEdge(from, to)
+	md.noteEdge(from, to)
 }
 
 func (md *MarkovData) weightedPick(wordNo uint) uint {
@@ -181,14 +252,28 @@ func (md *MarkovData) ReadInTextFile(filename string) error {
 func (md *MarkovData) GenerateSentence(limit int) (string, error) {
 	md.mutex.RLock()
 	defer md.mutex.RUnlock()
-	if md.WordCount == 0 {
+	if md.WordCount == 0 || len(md.StartWords) == 0 {
 		return "", errors.New("no data in markov database")
 	}
-	currWord := md.StartWords[rand.IntN(len(md.StartWords))]
+	return md.generateFrom(md.pickStart(), limit), nil
+}
+
+// generateFrom walks the chain from a word until it hits the end of a sentence or the limit
+// The caller must hold the read lock
+func (md *MarkovData) generateFrom(currWord uint, limit int) string {
+	output, _ := md.generatePath(currWord, limit)
+	return output
+}
+
+// generatePath is generateFrom that also hands back the edges it walked
+// The caller must hold the read lock
+func (md *MarkovData) generatePath(currWord uint, limit int) (string, []edge) {
 	output := md.WordVals[currWord]
+	path := []edge{}
 	x := 0
 	for x < limit {
-		nextWord := md.weightedPick(currWord)
+		nextWord := md.pickNext(currWord)
+		path = append(path, edge{currWord, nextWord})
 		if strings.Contains(".!?", md.WordVals[currWord]) {
 			output += md.WordVals[nextWord]
 			break
@@ -197,14 +282,22 @@ func (md *MarkovData) GenerateSentence(limit int) (string, error) {
 		currWord = nextWord
 		x++
 	}
-	return output, nil
+	return output, path
 }
 
-// SaveToFile outputs the data generated to a file, since it's not exactly human readable, it's just clumped together
-func (md *MarkovData) SaveToFile(filename string) error {
+// Encode serializes the chain the same way SaveToFile writes it, Decode reads it back
+func (md *MarkovData) Encode() ([]byte, error) {
 	md.mutex.RLock()
 	defer md.mutex.RUnlock()
-	outpStr, err := json.Marshal(md)
+	return json.Marshal(struct {
+		Version int `json:"Version"`
+		*MarkovData
+	}{CurrentVersion, md})
+}
+
+// SaveToFile outputs the data generated to a file, since it's not exactly human readable, it's just clumped together
+func (md *MarkovData) SaveToFile(filename string) error {
+	outpStr, err := md.Encode()
 	if err != nil {
 		return err
 	}
@@ -221,12 +314,9 @@ func (md *MarkovData) SaveToFile(filename string) error {
 		return errors.New("invalid file path provided")
 	}
 
-	file, err := os.Create(filename)
-	if err != nil {
+	// Written next to it then moved over, so the old file is still there if anything goes wrong
+	if err := os.WriteFile(filename+".tmp", outpStr, 0644); err != nil {
 		return err
 	}
-	defer file.Close()
-	file.Write(outpStr)
-	return nil
+	return os.Rename(filename+".tmp", filename)
 }
-
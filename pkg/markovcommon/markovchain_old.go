@@ -47,7 +47,10 @@ type MarkovDataOld struct {
 func (md *MarkovDataOld) SaveToFile(filename string) error {
 	md.mutex.RLock()
 	defer md.mutex.RUnlock()
-	outpStr, err := json.MarshalIndent(md, "", "\t")
+	outpStr, err := json.MarshalIndent(struct {
+		Version int `json:"Version"`
+		*MarkovDataOld
+	}{VersionUncompressed, md}, "", "\t")
 	if err != nil {
 		return err
 	}
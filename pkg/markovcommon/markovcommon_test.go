@@ -1,11 +1,15 @@
 package markovcommon
 
 import (
+	"errors"
+	"math"
 	"os"
 	"path"
 	"runtime"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func checkSubSlice[T comparable](s1 []T, s2 []T) bool {
@@ -95,3 +99,578 @@ func TestCheckValidPath(t *testing.T) {
 		t.Error("Valid file not writable")
 	}
 }
+
+func TestReadInFileMigratesOldFormat(t *testing.T) {
+	inp, err := ReadinFile(path.Join("testdata", "test.json"))
+	if err != nil {
+		t.Fatal("Could not read valid file.", err)
+	}
+	md, ok := inp.(*MarkovData)
+	if !ok {
+		t.Fatalf("Expected *MarkovData, got %T", inp)
+	}
+	if len(md.StartWords) != 7 {
+		t.Error("Expected 7 start words, got", len(md.StartWords))
+	}
+	// "\end" gets replaced by a full stop
+	if _, ok := md.WordRef["\\end"]; ok {
+		t.Error("Old stop marker was carried over")
+	}
+	if md.WordGraph[md.WordRef["string"]][md.WordRef["."]] != 2 {
+		t.Error("Expected \"string\" to end a sentence twice")
+	}
+
+	// Saving it again writes the current version
+	outFile := path.Join(t.TempDir(), "migrated.json")
+	if err := md.SaveToFile(outFile); err != nil {
+		t.Fatal("Error writing file", err)
+	}
+	data, _ := os.ReadFile(outFile)
+	if version, err := detectVersion(data); err != nil || version != CurrentVersion {
+		t.Error("Expected version", CurrentVersion, "got", version, err)
+	}
+}
+
+func TestReadInFileVersions(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]struct {
+		content string
+		err     error
+	}{
+		"newer.json":   {`{"Version":999,"WordGraph":[]}`, ErrNewerVersion},
+		"unknown.json": {`{"Version":-1}`, ErrUnknownVersion},
+		"garbage.json": {`{"Foo":"bar"}`, ErrUnknownFormat},
+	}
+	for name, tc := range tests {
+		fname := path.Join(dir, name)
+		os.WriteFile(fname, []byte(tc.content), 0644)
+		if _, err := ReadinFile(fname); !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", name, tc.err, err)
+		}
+	}
+
+	// Compressed files from before versioning are still read in
+	fname := path.Join(dir, "unversioned.json")
+	os.WriteFile(fname, []byte(`{"StartWords":[0],"WordCount":2,"WordMap":{"hi":0,".":1},"WordVals":["hi","."],"WordGraph":[{"1":1},{}]}`), 0644)
+	inp, err := ReadinFile(fname)
+	if err != nil {
+		t.Fatal("Failed to read unversioned file", err)
+	}
+	if md := inp.(*MarkovData); md.WordCount != 2 {
+		t.Error("Expected 2 words, got", md.WordCount)
+	}
+}
+
+func TestMergeAndSubtract(t *testing.T) {
+	first := &MarkovData{}
+	first.AddStringToData("the cat sat on the mat")
+	second := &MarkovData{}
+	second.AddStringToData("the dog sat on the log")
+
+	diff := first.Diff(second, 2)
+	if !checkSubSlice(diff.NewWords, []string{"dog", "log"}) || len(diff.NewWords) != 2 {
+		t.Error("Expected new words dog and log, got", diff.NewWords)
+	}
+	if diff.ChangedEdges == 0 || diff.NewEdges == 0 {
+		t.Error("Expected both new and changed edges, got", diff)
+	}
+
+	if err := first.Merge(second, 2); err != nil {
+		t.Fatal("Merge failed", err)
+	}
+	if first.WordGraph[first.WordRef["sat"]][first.WordRef["on"]] != 3 {
+		t.Error("Expected weighted count of 3 for sat -> on, got", first.WordGraph[first.WordRef["sat"]][first.WordRef["on"]])
+	}
+	if err := first.Merge(first, 1); err == nil {
+		t.Error("Merging a chain with itself should fail")
+	}
+
+	if err := first.Subtract(second); err != nil {
+		t.Fatal("Subtract failed", err)
+	}
+	if first.WordGraph[first.WordRef["sat"]][first.WordRef["on"]] != 2 {
+		t.Error("Expected count of 2 for sat -> on after subtracting")
+	}
+	first.Subtract(second)
+	if _, ok := first.WordGraph[first.WordRef["the"]][first.WordRef["dog"]]; ok {
+		t.Error("Edge the -> dog should be gone")
+	}
+	if !slices.Contains(first.StartWords, first.WordRef["the"]) {
+		t.Error("\"the\" should still be a start word")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("the cat sat on the mat")
+	md.AddStringToData("the cat sat on the mat")
+	md.AddStringToData("the cat sat on the hat")
+
+	stats := md.Prune(2)
+	if stats.EdgesRemoved != 2 {
+		t.Error("Expected the -> hat and hat -> . to be removed, got", stats)
+	}
+	if _, ok := md.WordRef["hat"]; ok {
+		t.Error("\"hat\" should have been pruned")
+	}
+	if int(md.WordCount) != len(md.WordVals) || len(md.WordVals) != len(md.WordGraph) || stats.WordsLeft != len(md.WordVals) {
+		t.Fatal("Word counts out of sync after pruning")
+	}
+	for word, ref := range md.WordRef {
+		if md.WordVals[ref] != word {
+			t.Error("Word", word, "has the wrong ID after pruning")
+		}
+	}
+	if md.WordGraph[md.WordRef["the"]][md.WordRef["mat"]] != 2 {
+		t.Error("Expected the -> mat to survive with a count of 2")
+	}
+	if len(md.StartWords) != 1 || md.WordVals[md.StartWords[0]] != "the" {
+		t.Error("Start words not renumbered properly", md.StartWords)
+	}
+	if _, err := md.GenerateSentence(10); err != nil {
+		t.Error("Failed to generate after pruning", err)
+	}
+
+	// Pruning edges without losing any words still has to clean up start words and authors
+	md = &MarkovData{}
+	md.AddStringFromAuthor("a", "the cat sat")
+	md.AddStringFromAuthor("a", "the cat sat")
+	md.AddStringFromAuthor("a", "cat the sat")
+	md.Prune(2)
+	if len(md.StartWords) != 1 || md.WordVals[md.StartWords[0]] != "the" {
+		t.Error("Expected cat to stop being a start word", md.StartWords)
+	}
+	for from, edges := range md.AuthorGraph[hashID("a")] {
+		for to := range edges {
+			if md.WordGraph[from][to] == 0 {
+				t.Error("Author graph kept a pruned edge", md.WordVals[from], md.WordVals[to])
+			}
+		}
+	}
+}
+
+func TestDecay(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return start }
+
+	if err := (&MarkovData{}).SetDecay(DecayConfig{Mode: DecayExponential}); err == nil {
+		t.Error("Expected an error for a missing half-life")
+	}
+
+	for _, mode := range []DecayMode{DecayExponential, DecayWindow} {
+		md := &MarkovData{}
+		if err := md.SetDecay(DecayConfig{Mode: mode, HalfLife: time.Hour, Window: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+		timeNow = func() time.Time { return start }
+		for i := 0; i < 10; i++ {
+			md.AddStringToData("old news")
+		}
+		timeNow = func() time.Time { return start.Add(48 * time.Hour) }
+		md.AddStringToData("fresh news")
+
+		for i := 0; i < 20; i++ {
+			if word := md.WordVals[md.pickStart()]; word != "fresh" {
+				t.Fatalf("%s: expected recent start word, got %s", mode, word)
+			}
+		}
+
+		// Once nothing is recent it falls back to the lifetime counts
+		timeNow = func() time.Time { return start.Add(1000 * time.Hour) }
+		if _, ok := md.decayedPick(md.WordRef["§"]); ok && mode == DecayWindow {
+			t.Error("Nothing should count in the window anymore")
+		}
+		if _, err := md.GenerateSentence(10); err != nil {
+			t.Error("Failed to generate", err)
+		}
+	}
+}
+
+func TestAuthorTracking(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringFromAuthor("alice", "cats are great")
+	md.AddStringFromAuthor("bob", "dogs are loud")
+	md.AddStringToData("birds are noisy")
+
+	for i := 0; i < 20; i++ {
+		msg, err := md.GenerateSentenceAs("alice", 10, 0)
+		if err != nil {
+			t.Fatal("Failed to generate", err)
+		}
+		if msg != "cats are great ." {
+			t.Fatal("Expected only alice's words, got", msg)
+		}
+	}
+
+	if _, err := md.GenerateSentenceAs("carol", 10, 0); !errors.Is(err, ErrUnknownAuthor) {
+		t.Error("Expected unknown author error, got", err)
+	}
+
+	// Renumbering keeps the author's edges pointing at the right words
+	md.Prune(1)
+	if msg, _ := md.GenerateSentenceAs("bob", 10, 0); msg != "dogs are loud ." {
+		t.Error("Expected only bob's words after pruning, got", msg)
+	}
+
+	md.ForgetAuthor("alice")
+	if _, err := md.GenerateSentenceAs("alice", 10, 0); err == nil {
+		t.Error("alice should have been forgotten")
+	}
+	if _, ok := md.WordRef["cats"]; !ok {
+		t.Error("Forgetting an author should leave the chain alone")
+	}
+}
+
+func TestUnlearn(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("the cat sat")
+	md.AddStringToData("the cat sat")
+	md.AddStringToData("the dog ran")
+	the, cat := md.WordRef["the"], md.WordRef["cat"]
+	words := md.WordCount
+
+	md.RemoveStringFromData("the cat sat")
+	if md.WordGraph[the][cat] != 1 {
+		t.Fatal("Expected the -> cat once, got", md.WordGraph[the][cat])
+	}
+	md.RemoveStringFromData("the cat sat")
+	if _, ok := md.WordGraph[the][cat]; ok {
+		t.Fatal("the -> cat should be gone")
+	}
+	if len(md.WordGraph[cat]) != 0 {
+		t.Fatal("cat -> sat should be gone")
+	}
+	if !slices.Contains(md.StartWords, the) {
+		t.Fatal("the still starts a sentence")
+	}
+
+	// Removing things the chain never saw doesn't make new words or touch anything else
+	md.RemoveStringFromData("the zebra sat")
+	if md.WordCount != words || md.WordGraph[the][md.WordRef["dog"]] != 1 {
+		t.Fatal("Removing an unknown string changed the chain")
+	}
+
+	md.RemoveStringFromData("the dog ran")
+	if len(md.StartWords) != 0 {
+		t.Fatal("Expected no start words left, got", md.StartWords)
+	}
+
+	md.AddStringFromAuthor("alice", "cats are great")
+	md.AddStringFromAuthor("alice", "cats are loud")
+	md.RemoveStringFromAuthor("alice", "cats are great")
+	if msg, _ := md.GenerateSentenceAs("alice", 10, 0); msg != "cats are loud ." {
+		t.Fatal("Expected only what alice still said, got", msg)
+	}
+	md.RemoveStringFromAuthor("alice", "cats are loud")
+	if len(md.AuthorGraph) != 0 {
+		t.Fatal("Expected alice to be gone, got", md.AuthorGraph)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	md := &MarkovData{}
+	md.AddMessage("m1", "alice", "the cat sat", false)
+	md.AddMessage("m2", "bob", "the cat sat", false)
+	md.AddMessage("m3", "alice", "the dog ran", true)
+
+	stats := md.ForgetUser("alice")
+	if stats.Messages != 2 || stats.Edges != 8 {
+		t.Fatal("Expected 2 messages and 8 edges forgotten, got", stats)
+	}
+	the, cat := md.WordRef["the"], md.WordRef["cat"]
+	if md.WordGraph[the][cat] != 1 || len(md.WordGraph[md.WordRef["dog"]]) != 0 {
+		t.Fatal("Expected only bob's message left")
+	}
+	if len(md.AuthorGraph) != 0 {
+		t.Fatal("alice's attributed edges weren't removed")
+	}
+
+	if !md.HasMessage("m2") || md.HasMessage("m1") || md.HasMessage("m9") {
+		t.Fatal("Expected only bob's message to still be known")
+	}
+	if removed, err := md.RemoveMessage("m2"); err != nil || removed != 4 {
+		t.Fatal("Expected 4 edges removed, got", removed, err)
+	}
+	if _, err := md.RemoveMessage("m2"); !errors.Is(err, ErrUnknownMessage) {
+		t.Fatal("Expected unknown message, got", err)
+	}
+	if len(md.StartWords) != 0 {
+		t.Fatal("Expected no start words left, got", md.StartWords)
+	}
+
+	// Records follow words around when pruning renumbers them
+	md.AddMessage("m4", "carol", "a b c", false)
+	md.AddMessage("m5", "carol", "a b c", false)
+	md.AddMessage("m6", "carol", "x y", false)
+	md.Prune(2)
+	if _, err := md.RemoveMessage("m6"); !errors.Is(err, ErrUnknownMessage) {
+		t.Fatal("Expected the pruned message to be gone, got", err)
+	}
+	if removed, _ := md.RemoveMessage("m4"); removed != 4 {
+		t.Fatal("Expected 4 edges removed after pruning, got", removed)
+	}
+	if md.WordGraph[md.WordRef["a"]][md.WordRef["b"]] != 1 {
+		t.Fatal("Expected a -> b once after removing one copy")
+	}
+
+	// With decay on, the forgotten share has to come out of the ages too, that's all generating looks at
+	md = &MarkovData{}
+	md.SetDecay(DecayConfig{Mode: DecayExponential, HalfLife: time.Hour, Window: time.Hour})
+	md.AddMessage("m1", "alice", "the cat", false)
+	md.AddMessage("m2", "alice", "the cat", false)
+	md.AddMessage("m3", "bob", "the cat", false)
+	md.ForgetUser("alice")
+	age := md.EdgeAges[md.WordRef["the"]][md.WordRef["cat"]]
+	if math.Abs(age.Decayed-1) > 0.01 || age.Window != 1 {
+		t.Fatal("Expected the edge's age to be scaled down to bob's share, got", age)
+	}
+
+	// Merged records point at the merged chain's word numbers, so forgetting still works after a merge
+	md = &MarkovData{}
+	md.AddMessage("m1", "bob", "a dog barked", false)
+	other := &MarkovData{}
+	other.AddMessage("m2", "alice", "the cat sat", true)
+	md.Merge(other, 1)
+	if stats := md.ForgetUser("alice"); stats.Messages != 1 || stats.Edges != 4 || len(md.WordGraph[md.WordRef["cat"]]) != 0 || len(md.AuthorGraph) != 0 {
+		t.Fatal("Expected alice's merged message to be forgotten, got", stats, md.AuthorGraph)
+	}
+	if !md.HasMessage("m1") || md.WordGraph[md.WordRef["a"]][md.WordRef["dog"]] != 1 {
+		t.Fatal("Expected bob's message to be left alone")
+	}
+
+	// Replacing keeps the same chain but takes everything from the other one
+	md.Replace(other)
+	if md.HasMessage("m1") || !md.HasMessage("m2") || md.WordCount != other.WordCount {
+		t.Fatal("Expected the other chain's contents after replacing")
+	}
+}
+
+func TestGenerateSentenceFrom(t *testing.T) {
+	words := ContentWords("Don't you like the purple elephants? I really like elephants!")
+	if !slices.Equal(words, []string{"purple", "elephants"}) {
+		t.Fatal("Expected purple and elephants, got", words)
+	}
+
+	md := &MarkovData{}
+	md.AddStringToData("the purple elephant dances")
+	md.AddStringToData("cats sleep")
+	for i := 0; i < 10; i++ {
+		msg, err := md.GenerateSentenceFrom([]string{"Zebra", "Purple"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(msg, "purple elephant dances") {
+			t.Fatal("Expected a sentence starting at purple, got", msg)
+		}
+	}
+	if _, err := md.GenerateSentenceFrom([]string{"zebra"}, 10); !errors.Is(err, ErrNoSeed) {
+		t.Fatal("Expected no seed, got", err)
+	}
+}
+
+func TestReinforce(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("cats chase mice")
+	md.AddStringToData("cats chase mice")
+	md.AddStringToData("cats sleep")
+	msg, path, err := md.GenerateSentencePath([]string{"mice"}, 10)
+	if err != nil || !strings.HasPrefix(msg, "mice") || len(path) == 0 || path[0] != (Step{"mice", "."}) {
+		t.Fatal("Expected a path from mice, got", msg, path, err)
+	}
+	if _, path, err := md.GenerateSentencePath(nil, 10); err != nil || path[0].From != "§" || path[0].To != "cats" {
+		t.Fatal("Expected the path to begin at the start of a sentence, got", path, err)
+	}
+
+	count := func(from string, to string) uint {
+		return md.WordGraph[md.WordRef[from]][md.WordRef[to]]
+	}
+	path = Path{{"§", "cats"}, {"cats", "chase"}, {"chase", "mice"}, {"mice", "."}, {"mice", "zebra"}}
+	if path[:4].String() != "cats chase mice ." {
+		t.Fatal("Unexpected path string", path.String())
+	}
+	if changed := md.Reinforce(path[:3], 2); len(changed) != 3 || count("cats", "chase") != 4 {
+		t.Fatal("Expected a boost, got", changed, count("cats", "chase"))
+	}
+	// Damping stops at 1, and steps that aren't in the chain are skipped
+	changed := md.Reinforce(path, -10)
+	if len(changed) != 4 || changed[0] != (StepChange{Step{"§", "cats"}, -4}) || count("cats", "chase") != 1 || count("mice", ".") != 1 {
+		t.Fatal("Expected edges damped down to 1, got", changed, count("cats", "chase"), count("mice", "."))
+	}
+	if changed := md.Reinforce(path, -1); len(changed) != 0 || count("cats", "sleep") != 1 {
+		t.Fatal("Expected nothing left to damp, got", changed)
+	}
+	// Undoing a damp that did nothing must not boost anything
+	md.Revert(md.Reinforce(path, -1))
+	if count("cats", "chase") != 1 || count("mice", ".") != 1 {
+		t.Fatal("Expected reverting a no-op to leave the edges alone, got", count("cats", "chase"), count("mice", "."))
+	}
+	md.Revert(changed)
+	if count("§", "cats") != 5 || count("cats", "chase") != 4 || count("chase", "mice") != 4 || count("mice", ".") != 2 {
+		t.Fatal("Expected reverting to put back exactly what was damped, got", count("§", "cats"), count("cats", "chase"), count("chase", "mice"), count("mice", "."))
+	}
+
+	// Decay only looks at the ages, so bad reactions have to reach them
+	md = &MarkovData{}
+	md.SetDecay(DecayConfig{Mode: DecayWindow, Window: time.Hour})
+	for i := 0; i < 4; i++ {
+		md.AddStringToData("cats chase mice")
+	}
+	md.Reinforce(Path{{"cats", "chase"}}, -2)
+	if age := md.EdgeAges[md.WordRef["cats"]][md.WordRef["chase"]]; age.Window != 2 {
+		t.Fatal("Expected damping to halve the edge's window count, got", age)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("the cat sat on the mat")
+	data, err := md.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.WordCount != md.WordCount || !slices.Equal(decoded.StartWords, md.StartWords) {
+		t.Fatal("Chain didn't survive encoding")
+	}
+
+	// A reference past the end of the words would crash generating
+	decoded.WordGraph[0][decoded.WordCount+5] = 1
+	data, err = decoded.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(data); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("Expected a corrupt chain, got", err)
+	}
+	if _, err := Decode([]byte("not a chain")); err == nil {
+		t.Fatal("Expected garbage to fail")
+	}
+
+	// Missing ages come through as null and learning with decay on would write into them
+	md = &MarkovData{}
+	md.SetDecay(DecayConfig{Mode: DecayWindow, Window: time.Hour})
+	md.AddStringToData("the cat sat on the mat")
+	md.EdgeAges[0] = nil
+	if data, err = md.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err = Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	decoded.AddStringToData("the cat sat on the mat")
+	if age := decoded.EdgeAges[0][decoded.WordRef["the"]]; age.Window != 1 {
+		t.Fatal("Expected the null ages to be filled in and learned into, got", age)
+	}
+}
+
+func TestStats(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("the cat sat on the mat.")
+	md.AddStringToData("the cat ran")
+	stats := md.Stats(2)
+	// the, cat, sat, on, mat, ran and the full stop
+	if stats.Words != 7 || stats.StartWords != 1 || stats.Learned != 2 {
+		t.Fatal("Unexpected counts", stats)
+	}
+	if !slices.Equal(stats.TopWords, []WordCount{{"the", 3}, {"cat", 2}}) {
+		t.Error("Unexpected top words", stats.TopWords)
+	}
+	if !slices.Equal(stats.TopBigrams, []BigramCount{{"the", "cat", 2}, {"cat", "ran", 1}}) {
+		t.Error("Unexpected top bigrams", stats.TopBigrams)
+	}
+	// § -> the, the -> cat/mat, cat -> sat/ran, sat -> on, on -> the, mat -> ., ran -> .
+	if stats.Edges != 9 || stats.Branching != 9.0/7 {
+		t.Error("Unexpected edges", stats.Edges, stats.Branching)
+	}
+	if empty := (&MarkovData{}).Stats(5); empty.Words != 0 || empty.Branching != 0 || len(empty.TopWords) != 0 {
+		t.Error("Expected an empty chain to have nothing", empty)
+	}
+}
+
+func TestExplore(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("the cat sat on the mat")
+	md.AddStringToData("the cat ran")
+	md.AddStringToData("a dog sat")
+
+	next, err := md.Next("the", 5)
+	if err != nil || !slices.Equal(next, []Neighbour{{"cat", 2, 2.0 / 3}, {"mat", 1, 1.0 / 3}}) {
+		t.Fatal("Unexpected followers of the", next, err)
+	}
+	if _, err := md.Next("zebra", 5); !errors.Is(err, ErrUnknownWord) {
+		t.Fatal("Expected an unknown word, got", err)
+	}
+
+	prev, err := md.Prev("sat", 1)
+	if err != nil || !slices.Equal(prev, []Neighbour{{"cat", 1, 0.5}}) {
+		t.Fatal("Unexpected words before sat", prev, err)
+	}
+	// The reverse index has to keep up with new edges and forget removed ones
+	md.AddStringToData("my cat sat")
+	md.decrementEdge(md.WordRef["dog"], md.WordRef["sat"], 1)
+	if prev, _ := md.Prev("sat", 5); !slices.Equal(prev, []Neighbour{{"cat", 2, 1}}) {
+		t.Fatal("Reverse index didn't keep up", prev)
+	}
+	md.Prune(1)
+	if prev, _ := md.Prev("mat", 5); !slices.Equal(prev, []Neighbour{{"the", 1, 1}}) {
+		t.Fatal("Reverse index didn't survive a prune", prev)
+	}
+
+	path, chance, err := md.Path("the", "on")
+	if err != nil || !slices.Equal(path, []string{"the", "cat", "sat", "on"}) {
+		t.Fatal("Unexpected path", path, err)
+	}
+	// the -> cat is 2/3, cat -> sat is 2/3 and sat -> on is 1/3
+	if math.Abs(chance-4.0/27) > 1e-9 {
+		t.Error("Unexpected chance", chance)
+	}
+	if _, _, err := md.Path("on", "my"); !errors.Is(err, ErrNoPath) {
+		t.Error("Expected no path, got", err)
+	}
+}
+
+func TestScore(t *testing.T) {
+	md := &MarkovData{}
+	md.AddStringToData("the cat sat on the mat")
+	md.AddStringToData("the cat sat on the mat")
+	md.AddStringToData("the dog ran")
+
+	likely, err := md.Score("the cat sat on the mat")
+	if err != nil || likely.Steps != 7 || len(likely.Unknown) != 0 {
+		t.Fatal("Unexpected score", likely, err)
+	}
+	// Known word, unseen step, it's possible but less likely
+	odd, err := md.Score("the mat sat on the cat")
+	if err != nil || odd.Perplexity <= likely.Perplexity || odd.LogProb >= likely.LogProb {
+		t.Fatal("Expected a worse score for a sentence the chain hasn't seen", odd, likely, err)
+	}
+	if math.Abs(likely.Probability()-math.Exp(likely.LogProb)) > 1e-12 {
+		t.Error("Probability doesn't match LogProb")
+	}
+
+	// Made up words are scored as the least likely thing there is, not skipped
+	unknown, err := md.Score("the zebra zebra sat on the mat")
+	if err != nil || !slices.Equal(unknown.Unknown, []string{"zebra"}) || unknown.Steps != 8 {
+		t.Fatal("Expected zebra to be unknown and scored", unknown, err)
+	}
+	if unknown.Perplexity <= odd.Perplexity {
+		t.Error("Expected made up words to be less likely than known ones in a strange order", unknown, odd)
+	}
+	if score, err := (&MarkovData{}).Score("zebra"); !errors.Is(err, ErrNothingToScore) || slices.Contains(score.Unknown, "§") {
+		t.Fatal("Expected nothing to score against an empty chain, got", score, err)
+	}
+	if _, err := md.Score(""); !errors.Is(err, ErrNothingToScore) {
+		t.Fatal("Expected nothing to score in empty text, got", err)
+	}
+
+	if best := MostLikely(md, []string{"zebra", "the mat sat on the cat", "the cat sat on the mat"}); best != 2 {
+		t.Error("Expected the most in character sentence to win, got", best)
+	}
+	if best := MostLikely(&MarkovData{}, []string{"zebra"}); best != -1 {
+		t.Error("Expected no winner, got", best)
+	}
+}
@@ -0,0 +1,221 @@
+package markovcommon
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// merge.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Combining two chains together, taking one away from another and previewing the result
+
+// EdgeChange describes how the count of a single edge moves
+type EdgeChange struct {
+	From   string // Word the edge comes from
+	To     string // Word the edge goes to
+	Before uint   // Count before the change
+	After  uint   // Count after the change
+}
+
+// ChainDiff summarises what merging one chain into another would do
+type ChainDiff struct {
+	NewWords      []string     // Words the base chain has never seen
+	NewStartWords []string     // Words that would start sentences for the first time
+	NewEdges      int          // Edges the base chain doesn't have yet
+	ChangedEdges  int          // Edges that exist in both and would get bigger
+	AddedCount    uint         // Total amount added across every edge
+	Changes       []EdgeChange // Every edge that changes, biggest increase first
+}
+
+func (cd ChainDiff) String() string {
+	var output string
+	output += "New words:\t\t" + strconv.Itoa(len(cd.NewWords)) + "\n"
+	output += "New start words:\t" + strconv.Itoa(len(cd.NewStartWords)) + "\n"
+	output += "New edges:\t\t" + strconv.Itoa(cd.NewEdges) + "\n"
+	output += "Changed edges:\t\t" + strconv.Itoa(cd.ChangedEdges) + "\n"
+	output += "Total count added:\t" + strconv.FormatUint(uint64(cd.AddedCount), 10) + "\n"
+	return output
+}
+
+// scaleCount applies a merge weight to an edge count
+func scaleCount(count uint, weight float64) uint {
+	return uint(math.Round(float64(count) * weight))
+}
+
+// Merge adds every edge in other to md, with other's counts multiplied by weight
+// Edges that round down to nothing are skipped, and merged edges carry no age so decay treats them as old
+// Author graphs come across scaled the same way, message records only at a weight of 1 since they have to match the counts exactly
+func (md *MarkovData) Merge(other *MarkovData, weight float64) error {
+	if other == md {
+		return errors.New("can't merge a chain with itself")
+	}
+	if weight <= 0 {
+		return errors.New("merge weight must be above 0")
+	}
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	md.initialise()
+
+	for from, edges := range other.WordGraph {
+		for to, count := range edges {
+			scaled := scaleCount(count, weight)
+			if scaled == 0 {
+				continue
+			}
+			fromRef, toRef := md.getWordRef(other.WordVals[from]), md.getWordRef(other.WordVals[to])
+			md.WordGraph[fromRef][toRef] += scaled
+			md.noteEdge(fromRef, toRef)
+		}
+	}
+	for _, v := range other.StartWords {
+		val := md.getWordRef(other.WordVals[v])
+		if !slices.Contains(md.StartWords, val) {
+			md.StartWords = append(md.StartWords, val)
+		}
+	}
+	for author, graph := range other.AuthorGraph {
+		for from, edges := range graph {
+			for to, count := range edges {
+				if scaled := scaleCount(count, weight); scaled > 0 {
+					md.attributeEdge(author, md.getWordRef(other.WordVals[from]), md.getWordRef(other.WordVals[to]), scaled)
+				}
+			}
+		}
+	}
+	if weight == 1 {
+		md.mergeRecords(other)
+	}
+	md.Learned += other.Learned
+	return nil
+}
+
+// Replace swaps everything in md for what's in other, so anything holding md sees the new chain
+// other shouldn't be used afterwards, md takes over its maps
+func (md *MarkovData) Replace(other *MarkovData) {
+	if other == md {
+		return
+	}
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	md.StartWords, md.WordCount, md.WordRef, md.WordVals, md.WordGraph = other.StartWords, other.WordCount, other.WordRef, other.WordVals, other.WordGraph
+	md.Decay, md.EdgeAges, md.AuthorGraph, md.Messages, md.Learned = other.Decay, other.EdgeAges, other.AuthorGraph, other.Messages, other.Learned
+	md.reverseMutex.Lock()
+	md.reverse = nil
+	md.reverseMutex.Unlock()
+	md.initialise()
+}
+
+// Subtract takes every edge in other away from md
+// Edges that hit zero are dropped, words are left in place
+func (md *MarkovData) Subtract(other *MarkovData) error {
+	if other == md {
+		return errors.New("can't subtract a chain from itself")
+	}
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	md.initialise()
+
+	for from, edges := range other.WordGraph {
+		fromRef, ok := md.WordRef[other.WordVals[from]]
+		if !ok {
+			continue
+		}
+		for to, count := range edges {
+			toRef, ok := md.WordRef[other.WordVals[to]]
+			if !ok {
+				continue
+			}
+			md.decrementEdge(fromRef, toRef, count)
+		}
+	}
+
+	// Start words only go when nothing else starts a sentence with them
+	startRef, ok := md.WordRef["§"]
+	if !ok {
+		return nil
+	}
+	md.StartWords = slices.DeleteFunc(md.StartWords, func(val uint) bool {
+		_, ok := md.WordGraph[startRef][val]
+		return !ok
+	})
+	return nil
+}
+
+// decrementEdge lowers an edge's count, removing it when it runs out
+// The edge's age is scaled down to match, decay only looks at the ages so it'd never notice otherwise
+func (md *MarkovData) decrementEdge(from uint, to uint, count uint) {
+	current := md.WordGraph[from][to]
+	if current <= count {
+		delete(md.WordGraph[from], to)
+		if from < uint(len(md.EdgeAges)) {
+			delete(md.EdgeAges[from], to)
+		}
+		return
+	}
+	md.WordGraph[from][to] -= count
+	if from >= uint(len(md.EdgeAges)) {
+		return
+	}
+	if age, ok := md.EdgeAges[from][to]; ok {
+		md.EdgeAges[from][to] = age.scale(float64(current-count) / float64(current))
+	}
+}
+
+// Diff works out what Merge(other, weight) would do to md without changing anything
+func (md *MarkovData) Diff(other *MarkovData, weight float64) ChainDiff {
+	output := ChainDiff{NewWords: []string{}, NewStartWords: []string{}, Changes: []EdgeChange{}}
+	if other != md {
+		md.mutex.RLock()
+		defer md.mutex.RUnlock()
+		other.mutex.RLock()
+		defer other.mutex.RUnlock()
+	}
+
+	for _, word := range other.WordVals {
+		if _, ok := md.WordRef[word]; !ok {
+			output.NewWords = append(output.NewWords, word)
+		}
+	}
+	for _, v := range other.StartWords {
+		ref, ok := md.WordRef[other.WordVals[v]]
+		if !ok || !slices.Contains(md.StartWords, ref) {
+			output.NewStartWords = append(output.NewStartWords, other.WordVals[v])
+		}
+	}
+
+	for from, edges := range other.WordGraph {
+		for to, count := range edges {
+			scaled := scaleCount(count, weight)
+			if scaled == 0 {
+				continue
+			}
+			change := EdgeChange{From: other.WordVals[from], To: other.WordVals[to]}
+			fromRef, ok := md.WordRef[change.From]
+			toRef, ok1 := md.WordRef[change.To]
+			if ok && ok1 {
+				change.Before = md.WordGraph[fromRef][toRef]
+			}
+			if change.Before == 0 {
+				output.NewEdges++
+			} else {
+				output.ChangedEdges++
+			}
+			change.After = change.Before + scaled
+			output.AddedCount += scaled
+			output.Changes = append(output.Changes, change)
+		}
+	}
+	slices.SortFunc(output.Changes, func(a, b EdgeChange) int {
+		return int(b.After-b.Before) - int(a.After-a.Before)
+	})
+	return output
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+)
+
+// mergedb
+// Combines two saved databases, takes one away from another, or shows what a merge would change
+
+// readDatabase reads in a database and makes sure it's the compressed kind
+func readDatabase(filename string) (*markovcommon.MarkovData, error) {
+	data, err := markovcommon.ReadinFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := data.(*markovcommon.MarkovData)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a compressed database", filename)
+	}
+	return md, nil
+}
+
+func main() {
+	var first string
+	var second string
+	var output string
+	var mode string
+	var firstWeight float64
+	var secondWeight float64
+	var top int
+
+	flag.StringVar(&first, "a", "", "Base database")
+	flag.StringVar(&second, "b", "", "Database to merge into/subtract from the base")
+	flag.StringVar(&output, "output", "output.json", "Output database")
+	flag.StringVar(&mode, "mode", "diff", "One of merge, subtract or diff")
+	flag.Float64Var(&firstWeight, "wa", 1, "Weighting applied to the base database when merging")
+	flag.Float64Var(&secondWeight, "wb", 1, "Weighting applied to the second database when merging")
+	flag.IntVar(&top, "top", 20, "How many of the biggest edge changes to show in a diff")
+	flag.Parse()
+
+	if first == "" || second == "" {
+		fmt.Println("Both -a and -b must be passed, exiting.")
+		return
+	}
+
+	base, err := readDatabase(first)
+	if err != nil {
+		fmt.Println("Error occurred", err.Error())
+		return
+	}
+	other, err := readDatabase(second)
+	if err != nil {
+		fmt.Println("Error occurred", err.Error())
+		return
+	}
+
+	switch mode {
+	case "diff":
+		{
+			diff := base.Diff(other, secondWeight)
+			fmt.Print(diff)
+			for i, change := range diff.Changes {
+				if i == top {
+					break
+				}
+				fmt.Printf("%s -> %s: %d -> %d\n", change.From, change.To, change.Before, change.After)
+			}
+			return
+		}
+	case "merge":
+		{
+			result := &markovcommon.MarkovData{}
+			if err := result.Merge(base, firstWeight); err != nil {
+				fmt.Println("Error occurred", err.Error())
+				return
+			}
+			if err := result.Merge(other, secondWeight); err != nil {
+				fmt.Println("Error occurred", err.Error())
+				return
+			}
+			base = result
+		}
+	case "subtract":
+		{
+			if err := base.Subtract(other); err != nil {
+				fmt.Println("Error occurred", err.Error())
+				return
+			}
+		}
+	default:
+		{
+			fmt.Println("Invalid mode", mode)
+			return
+		}
+	}
+
+	if err := base.SaveToFile(output); err != nil {
+		fmt.Println("Error occurred while saving", err.Error())
+	}
+}
@@ -0,0 +1,139 @@
+package markovcommon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// migrate.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Every saved database carries a "Version" field, older files get upgraded one step at a time when read in
+
+const (
+	VersionUncompressed = 0 // MarkovDataOld, files from before versioning have no Version field
+	VersionCompressed   = 1 // MarkovData
+	CurrentVersion      = VersionCompressed
+)
+
+var (
+	ErrUnknownFormat  = errors.New("file is not a markov database")
+	ErrUnknownVersion = errors.New("unknown markov database version")
+	ErrNewerVersion   = errors.New("markov database was written by a newer version of this program")
+)
+
+// migration upgrades the raw contents of a file from one version to the next
+type migration func(data []byte) ([]byte, error)
+
+// migrations maps a version to the step that upgrades it to version+1
+var migrations = map[int]migration{
+	VersionUncompressed: migrateUncompressed,
+}
+
+// detectVersion works out which version a file was saved as
+// Files from before versioning are told apart by the keys they contain
+func detectVersion(data []byte) (int, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return 0, err
+	}
+	if raw, ok := keys["Version"]; ok {
+		var version int
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownVersion, string(raw))
+		}
+		return version, nil
+	}
+	if _, ok := keys["WordGraph"]; ok {
+		return VersionCompressed, nil
+	}
+	if _, ok := keys["Wordmaps"]; ok {
+		return VersionUncompressed, nil
+	}
+	return 0, ErrUnknownFormat
+}
+
+// migrate upgrades data to CurrentVersion
+func migrate(data []byte) ([]byte, error) {
+	version, err := detectVersion(data)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("%w: got version %d, newest supported is %d", ErrNewerVersion, version, CurrentVersion)
+	}
+	for version < CurrentVersion {
+		step, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+		if data, err = step(data); err != nil {
+			return nil, fmt.Errorf("migrating from version %d: %w", version, err)
+		}
+		version++
+	}
+	return data, nil
+}
+
+// decodeData upgrades and deserializes the contents of a database file
+func decodeData(data []byte) (*MarkovData, error) {
+	data, err := migrate(data)
+	if err != nil {
+		return &MarkovData{}, err
+	}
+	var outp MarkovData
+	if err := json.Unmarshal(data, &outp); err != nil {
+		return &MarkovData{}, err
+	}
+	return &outp, nil
+}
+
+// migrateUncompressed converts a MarkovDataOld database into a MarkovData one
+// This used to live in compressdb
+func migrateUncompressed(data []byte) ([]byte, error) {
+	var inp MarkovDataOld
+	if err := json.Unmarshal(data, &inp); err != nil {
+		return nil, err
+	}
+	return json.Marshal(CompressData(&inp))
+}
+
+// CompressData builds a MarkovData struct holding the same chain as a MarkovDataOld one
+func CompressData(inp *MarkovDataOld) *MarkovData {
+	inp.mutex.RLock()
+	defer inp.mutex.RUnlock()
+	outp := &MarkovData{
+		StartWords: []uint{},
+		WordRef:    map[string]uint{},
+		WordVals:   []string{},
+		WordGraph:  []map[uint]uint{},
+	}
+
+	// "\end" is the old stop marker, "." does that job now
+	ref := func(word string) uint {
+		if word == "\\end" {
+			word = "."
+		}
+		return outp.getWordRef(word)
+	}
+	ref(".")
+	for k, v := range inp.Wordmaps {
+		from := ref(k)
+		for k1, v1 := range v {
+			to := ref(k1)
+			outp.WordGraph[from][to] += uint(v1)
+		}
+	}
+
+	// Start words don't have counts in the old format so they all get equal weighting
+	for _, v := range inp.Startwords {
+		val := ref(v)
+		if !slices.Contains(outp.StartWords, val) {
+			outp.StartWords = append(outp.StartWords, val)
+			outp.WordGraph[ref("§")][val]++
+		}
+	}
+	return outp
+}
@@ -0,0 +1,165 @@
+package markovcommon
+
+import (
+	"errors"
+	"strconv"
+)
+
+// provenance.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Remembering which message added which edges, so a message or everything someone said can be taken back out exactly
+// IDs are hashed to keep them small, not to hide them, anyone with the file can hash a user's ID and look for it
+
+var ErrUnknownMessage = errors.New("that message wasn't learned from")
+
+// ProvenanceTracker is implemented by chains that can keep track of where each edge came from
+type ProvenanceTracker interface {
+	AddMessage(messageId string, author string, input string, attribute bool) error
+	HasMessage(messageId string) bool
+	RemoveMessage(messageId string) (uint, error)
+	ForgetUser(author string) ForgetStats
+}
+
+// MessageRecord is what a single message added to the chain
+type MessageRecord struct {
+	Author     uint64 `json:"Author"`               // Hashed author ID
+	Attributed bool   `json:"Attributed,omitempty"` // The edges were also added to the author's AuthorGraph
+	Edges      []uint `json:"Edges"`                // Pairs of from, to word numbers
+}
+
+// ForgetStats says how much ForgetUser took out
+type ForgetStats struct {
+	Messages int  // Messages removed
+	Edges    uint // Edge counts removed
+}
+
+func (fs ForgetStats) String() string {
+	return strconv.Itoa(fs.Messages) + " messages (" + strconv.FormatUint(uint64(fs.Edges), 10) + " word links)"
+}
+
+// HasMessage checks if a message has been learned and not taken back out since
+func (md *MarkovData) HasMessage(messageId string) bool {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	_, ok := md.Messages[hashID(messageId)]
+	return ok
+}
+
+// AddMessage learns a message and remembers what it added
+// If attribute is set the edges are also recorded against the author like AddStringFromAuthor
+func (md *MarkovData) AddMessage(messageId string, author string, input string, attribute bool) error {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	edges, err := md.addString(input)
+	if err != nil {
+		return err
+	}
+	if attribute && author != "" {
+		md.attributeEdges(author, edges)
+	}
+
+	if md.Messages == nil {
+		md.Messages = map[uint64]*MessageRecord{}
+	}
+	record := &MessageRecord{Author: hashID(author), Attributed: attribute, Edges: make([]uint, 0, len(edges)*2)}
+	key := hashID(messageId)
+	// Learning the same message twice would lose track of the first lot of edges, so add them to the record
+	if old, ok := md.Messages[key]; ok {
+		record.Edges = append(record.Edges, old.Edges...)
+		record.Attributed = old.Attributed && attribute
+	}
+	for _, e := range edges {
+		record.Edges = append(record.Edges, e.From, e.To)
+	}
+	md.Messages[key] = record
+	return nil
+}
+
+// RemoveMessage takes exactly what a message added back out of the chain, returning how many edge counts it removed
+func (md *MarkovData) RemoveMessage(messageId string) (uint, error) {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	key := hashID(messageId)
+	record, ok := md.Messages[key]
+	if !ok {
+		return 0, ErrUnknownMessage
+	}
+	delete(md.Messages, key)
+	return md.removeRecord(record), nil
+}
+
+// ForgetUser removes everything recorded against an author
+// Anything they said before tracking started can't be found so it stays
+func (md *MarkovData) ForgetUser(author string) ForgetStats {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	stats := ForgetStats{}
+	hashed := hashID(author)
+	for key, record := range md.Messages {
+		if record.Author != hashed {
+			continue
+		}
+		stats.Messages++
+		stats.Edges += md.removeRecord(record)
+		delete(md.Messages, key)
+	}
+	delete(md.AuthorGraph, hashed)
+	return stats
+}
+
+// removeRecord decrements every edge in a record, the caller must hold the write lock
+func (md *MarkovData) removeRecord(record *MessageRecord) uint {
+	removed := []edge{}
+	for idx := 0; idx+1 < len(record.Edges); idx += 2 {
+		e := edge{record.Edges[idx], record.Edges[idx+1]}
+		if e.From >= md.WordCount || md.WordGraph[e.From][e.To] == 0 {
+			// Pruned since
+			continue
+		}
+		md.decrementEdge(e.From, e.To, 1)
+		removed = append(removed, e)
+	}
+	md.dropStartWords(removed)
+	if record.Attributed {
+		md.unattributeEdges(record.Author, removed)
+	}
+	return uint(len(removed))
+}
+
+// mergeRecords copies other's message records into md, renumbering the words to md's, the caller must hold both locks
+// A message both chains learned gets both lots of edges, the same as AddMessage learning it twice
+func (md *MarkovData) mergeRecords(other *MarkovData) {
+	if len(other.Messages) > 0 && md.Messages == nil {
+		md.Messages = map[uint64]*MessageRecord{}
+	}
+	for key, record := range other.Messages {
+		merged := &MessageRecord{Author: record.Author, Attributed: record.Attributed, Edges: make([]uint, 0, len(record.Edges))}
+		if old, ok := md.Messages[key]; ok {
+			merged.Edges = append(merged.Edges, old.Edges...)
+			merged.Attributed = old.Attributed && record.Attributed
+		}
+		for _, ref := range record.Edges {
+			merged.Edges = append(merged.Edges, md.getWordRef(other.WordVals[ref]))
+		}
+		md.Messages[key] = merged
+	}
+}
+
+// remapRecords renumbers every message record after a prune, dropping edges to words that are gone
+func (md *MarkovData) remapRecords(keep []bool, newRefs []uint) {
+	for key, record := range md.Messages {
+		edges := record.Edges[:0]
+		for idx := 0; idx+1 < len(record.Edges); idx += 2 {
+			from, to := record.Edges[idx], record.Edges[idx+1]
+			if keep[from] && keep[to] {
+				edges = append(edges, newRefs[from], newRefs[to])
+			}
+		}
+		if len(edges) == 0 {
+			delete(md.Messages, key)
+			continue
+		}
+		record.Edges = edges
+	}
+}
@@ -0,0 +1,168 @@
+package markovcommon
+
+import (
+	"slices"
+	"strconv"
+)
+
+// prune.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Gets rid of rare edges and the words left hanging after, then packs the IDs back together
+
+// Pruner is implemented by chains that can be compacted
+type Pruner interface {
+	Prune(minCount uint) PruneStats
+}
+
+// PruneStats reports what a call to Prune got rid of
+type PruneStats struct {
+	EdgesRemoved int // Edges below the threshold
+	WordsRemoved int // Words with nothing going in or out of them afterwards
+	WordsLeft    int // Size of the vocabulary afterwards
+}
+
+func (ps PruneStats) String() string {
+	return "Removed " + strconv.Itoa(ps.EdgesRemoved) + " edges and " + strconv.Itoa(ps.WordsRemoved) +
+		" words, " + strconv.Itoa(ps.WordsLeft) + " words left"
+}
+
+// Prune drops every edge with a count below minCount along with any words that are no longer connected to anything
+// Word IDs are renumbered so there are no gaps, so anything holding on to an old ID needs to look it up again
+func (md *MarkovData) Prune(minCount uint) PruneStats {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	md.initialise()
+	stats := PruneStats{}
+
+	// These are always needed for generation
+	stop := md.getWordRef(".")
+	start := md.getWordRef("§")
+
+	// Drop the edges first
+	used := make([]bool, md.WordCount)
+	for from, edges := range md.WordGraph {
+		for to, count := range edges {
+			if count < minCount {
+				delete(edges, to)
+				if from < len(md.EdgeAges) {
+					delete(md.EdgeAges[from], to)
+				}
+				stats.EdgesRemoved++
+				continue
+			}
+			used[from] = true
+			used[to] = true
+		}
+	}
+	used[stop] = true
+	used[start] = true
+	md.dropPrunedEdges(start)
+
+	// Then hand out new IDs to whatever is left
+	newRefs := make([]uint, len(used))
+	var next uint
+	for idx := range md.WordVals {
+		if !used[idx] {
+			stats.WordsRemoved++
+			continue
+		}
+		newRefs[idx] = next
+		next++
+	}
+	if stats.WordsRemoved == 0 {
+		stats.WordsLeft = int(md.WordCount)
+		return stats
+	}
+	md.remap(used, newRefs, next)
+	stats.WordsLeft = int(md.WordCount)
+	return stats
+}
+
+// dropPrunedEdges takes the edges Prune just removed out of the start words and every author's graph
+// Otherwise typos would still start sentences or come back through impersonate
+func (md *MarkovData) dropPrunedEdges(start uint) {
+	md.StartWords = slices.DeleteFunc(md.StartWords, func(val uint) bool {
+		_, ok := md.WordGraph[start][val]
+		return !ok
+	})
+	for _, graph := range md.AuthorGraph {
+		for from, edges := range graph {
+			for to := range edges {
+				if from >= uint(len(md.WordGraph)) || md.WordGraph[from][to] == 0 {
+					delete(edges, to)
+				}
+			}
+			if len(edges) == 0 {
+				delete(graph, from)
+			}
+		}
+	}
+}
+
+// remap rewrites every word reference using newRefs, dropping any word that isn't kept
+func (md *MarkovData) remap(keep []bool, newRefs []uint, count uint) {
+	wordVals := make([]string, 0, count)
+	wordGraph := make([]map[uint]uint, 0, count)
+	wordRef := make(map[string]uint, count)
+	edgeAges := []map[uint]EdgeAge{}
+	for idx, word := range md.WordVals {
+		if !keep[idx] {
+			continue
+		}
+		edges := map[uint]uint{}
+		for to, v := range md.WordGraph[idx] {
+			edges[newRefs[to]] = v
+		}
+		wordVals = append(wordVals, word)
+		wordGraph = append(wordGraph, edges)
+		wordRef[word] = newRefs[idx]
+
+		if idx < len(md.EdgeAges) {
+			ages := map[uint]EdgeAge{}
+			for to, v := range md.EdgeAges[idx] {
+				ages[newRefs[to]] = v
+			}
+			edgeAges = append(edgeAges, ages)
+		}
+	}
+
+	startWords := []uint{}
+	for _, v := range md.StartWords {
+		if keep[v] {
+			startWords = append(startWords, newRefs[v])
+		}
+	}
+
+	md.WordVals = wordVals
+	md.WordGraph = wordGraph
+	md.reverse = nil // Every number changed, it gets rebuilt next time it's needed
+	for author, graph := range md.AuthorGraph {
+		md.AuthorGraph[author] = remapGraph(graph, keep, newRefs)
+	}
+	md.remapRecords(keep, newRefs)
+	if len(md.EdgeAges) > 0 {
+		md.EdgeAges = edgeAges
+	}
+	md.WordRef = wordRef
+	md.StartWords = startWords
+	md.WordCount = count
+}
+
+// remapGraph renumbers a sparse word graph, dropping any words that aren't kept
+func remapGraph(graph map[uint]map[uint]uint, keep []bool, newRefs []uint) map[uint]map[uint]uint {
+	output := map[uint]map[uint]uint{}
+	for from, edges := range graph {
+		if !keep[from] {
+			continue
+		}
+		newEdges := map[uint]uint{}
+		for to, v := range edges {
+			if keep[to] {
+				newEdges[newRefs[to]] = v
+			}
+		}
+		output[newRefs[from]] = newEdges
+	}
+	return output
+}
@@ -0,0 +1,86 @@
+package markovcommon
+
+import (
+	"errors"
+	"math"
+	"slices"
+)
+
+// score.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: How likely the chain is to say something, for fun and for picking the best of a few generated sentences
+// Steps the chain has never seen get add-one smoothing so one odd pair of words doesn't make a sentence impossible
+// Words it has never seen count as one extra word in the vocabulary, so they get the smallest chance there is
+
+var ErrNothingToScore = errors.New("there's nothing to score, either the text or the chain is empty")
+
+// Scorer is implemented by chains that can say how likely a sentence is
+type Scorer interface {
+	Score(input string) (Score, error)
+}
+
+// Score is how well a sentence fits a chain
+type Score struct {
+	LogProb    float64  // Natural log of the chance of the chain saying it
+	Perplexity float64  // How surprised the chain is on average per step, lower is more in character
+	Steps      int      // Word to word steps scored, including the start and end of each sentence
+	Unknown    []string // Words the chain has never seen, each once
+}
+
+// Probability is the chance of the chain saying the whole thing, tiny for anything long
+func (s Score) Probability() float64 {
+	return math.Exp(s.LogProb)
+}
+
+// Score works out the log-probability and perplexity of a sentence the same way it'd be learned
+// An empty chain has nothing to compare against, so it gives back ErrNothingToScore like empty text does
+func (md *MarkovData) Score(input string) (Score, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	output := Score{Unknown: []string{}}
+	if len(md.WordVals) == 0 {
+		return output, ErrNothingToScore
+	}
+	// Every unknown word shares a number past the end of the vocabulary, it has no edges either way
+	unknown := uint(len(md.WordVals))
+	edges := walkEdges(input, func(word string) (uint, bool) {
+		if ref, ok := md.WordRef[word]; ok {
+			return ref, true
+		}
+		if word != "§" && !slices.Contains(output.Unknown, word) {
+			output.Unknown = append(output.Unknown, word)
+		}
+		return unknown, true
+	})
+
+	vocab := float64(len(md.WordVals) + 1)
+	for _, e := range edges {
+		var count, total float64
+		if e.From != unknown {
+			count = float64(md.WordGraph[e.From][e.To])
+			total = float64(md.outgoing(e.From))
+		}
+		output.LogProb += math.Log((count + 1) / (total + vocab))
+		output.Steps++
+	}
+	if output.Steps == 0 {
+		return output, ErrNothingToScore
+	}
+	output.Perplexity = math.Exp(-output.LogProb / float64(output.Steps))
+	return output, nil
+}
+
+// MostLikely picks the candidate with the lowest perplexity, -1 if none of them could be scored
+func MostLikely(chain Scorer, candidates []string) int {
+	best := -1
+	bestPerplexity := math.Inf(1)
+	for idx, candidate := range candidates {
+		score, err := chain.Score(candidate)
+		if err == nil && score.Perplexity < bestPerplexity {
+			best = idx
+			bestPerplexity = score.Perplexity
+		}
+	}
+	return best
+}
@@ -0,0 +1,76 @@
+package markovcommon
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+)
+
+// seed.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Generating sentences that start from particular words, so replies can stay on topic
+
+var ErrNoSeed = errors.New("none of the seed words are known")
+
+// Seeder is implemented by chains that can start a sentence from a given word
+type Seeder interface {
+	GenerateSentenceFrom(seeds []string, limit int) (string, error)
+}
+
+// stopWords are too common to say anything about what a message is about
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`a about after again all also am an and any are as at be because been
+		before being but by can could did do does doing done for from get got had has have having he her here hers
+		him his how i if im in into is it its just like me more most my no not now of off on once only or other
+		our out over really same she so some such than that thats the their them then there these they this those
+		through to too under until up very was we were what when where which while who why will with would yes you
+		your yours dont cant wont isnt arent lol lmao ok okay yeah oh`) {
+		stopWords[word] = true
+	}
+}
+
+// ContentWords picks out the words in a message that say what it's about, in the order they appear
+func ContentWords(input string) []string {
+	output := []string{}
+	seen := map[string]bool{}
+	for _, word := range tokenize(input) {
+		// Punctuation isn't always split off the end of a word
+		word = strings.TrimRight(word, ",.!?")
+		lower := strings.ReplaceAll(strings.ToLower(word), "'", "")
+		if len(word) < 3 || stopWords[lower] || seen[lower] || strings.ContainsAny(word, ",.!?<>@:/\\") {
+			continue
+		}
+		seen[lower] = true
+		output = append(output, word)
+	}
+	return output
+}
+
+// GenerateSentenceFrom starts a sentence at one of the seed words, picked at random out of the ones the chain knows
+// Seeds are tried as they are and in lower case, ErrNoSeed means none of them could be used
+func (md *MarkovData) GenerateSentenceFrom(seeds []string, limit int) (string, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	candidates := md.seedWords(seeds)
+	if len(candidates) == 0 {
+		return "", ErrNoSeed
+	}
+	return md.generateFrom(candidates[rand.IntN(len(candidates))], limit), nil
+}
+
+// seedWords finds the seeds the chain can start from, the caller must hold the read lock
+func (md *MarkovData) seedWords(seeds []string) []uint {
+	output := []uint{}
+	for _, seed := range seeds {
+		for _, word := range []string{seed, strings.ToLower(seed)} {
+			if ref, ok := md.WordRef[word]; ok && len(md.WordGraph[ref]) > 0 {
+				output = append(output, ref)
+				break
+			}
+		}
+	}
+	return output
+}
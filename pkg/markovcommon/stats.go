@@ -0,0 +1,97 @@
+package markovcommon
+
+import (
+	"slices"
+	"strings"
+)
+
+// stats.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Numbers about what's inside a chain, for showing off how much it's learned
+
+// StatsReporter is implemented by chains that can describe themselves
+type StatsReporter interface {
+	Stats(top int) ChainStats
+}
+
+// WordCount is how many times a word was seen
+type WordCount struct {
+	Word  string
+	Count uint
+}
+
+// BigramCount is how many times one word followed another
+type BigramCount struct {
+	From  string
+	To    string
+	Count uint
+}
+
+// ChainStats is a summary of a chain
+type ChainStats struct {
+	Words      int           // Size of the vocabulary, not counting the start marker
+	Edges      int           // Distinct word to word links
+	StartWords int           // Words that can start a sentence
+	Learned    uint          // Messages or text files learned from
+	Branching  float64       // Average number of ways a word can go, over words that go anywhere
+	TopWords   []WordCount   // Most common words, most common first, punctuation left out
+	TopBigrams []BigramCount // Most common pairs of words, most common first, punctuation left out
+}
+
+// isPunctuation is for keeping full stops and commas out of the top lists, they'd always win
+func isPunctuation(word string) bool {
+	return word == "§" || strings.Trim(word, ".,!?") == ""
+}
+
+// Stats counts up what's in the chain, with up to top entries in each of the top lists
+func (md *MarkovData) Stats(top int) ChainStats {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+	output := ChainStats{StartWords: len(md.StartWords), Learned: md.Learned, TopWords: []WordCount{}, TopBigrams: []BigramCount{}}
+
+	// A word's count is how often something led to it, which is every time it was said
+	counts := make([]uint, len(md.WordVals))
+	branching := 0
+	for from, edges := range md.WordGraph {
+		if len(edges) > 0 {
+			branching++
+		}
+		output.Edges += len(edges)
+		for to, count := range edges {
+			counts[to] += count
+			if !isPunctuation(md.WordVals[from]) && !isPunctuation(md.WordVals[to]) {
+				output.TopBigrams = append(output.TopBigrams, BigramCount{md.WordVals[from], md.WordVals[to], count})
+			}
+		}
+	}
+	if branching > 0 {
+		output.Branching = float64(output.Edges) / float64(branching)
+	}
+	for ref, word := range md.WordVals {
+		if word == "§" {
+			continue
+		}
+		output.Words++
+		if !isPunctuation(word) && counts[ref] > 0 {
+			output.TopWords = append(output.TopWords, WordCount{word, counts[ref]})
+		}
+	}
+
+	// Ties go alphabetically so the output doesn't jump around between calls
+	slices.SortFunc(output.TopWords, func(a, b WordCount) int {
+		if a.Count != b.Count {
+			return int(b.Count) - int(a.Count)
+		}
+		return strings.Compare(a.Word, b.Word)
+	})
+	slices.SortFunc(output.TopBigrams, func(a, b BigramCount) int {
+		if a.Count != b.Count {
+			return int(b.Count) - int(a.Count)
+		}
+		return strings.Compare(a.From+" "+a.To, b.From+" "+b.To)
+	})
+	output.TopWords = output.TopWords[:min(top, len(output.TopWords))]
+	output.TopBigrams = output.TopBigrams[:min(top, len(output.TopBigrams))]
+	return output
+}
@@ -37,7 +37,6 @@ func main() {
 
 	myMarkov.ReadInTextFile(inputFile)
 	myMarkov.SaveToFile(database)
-	myMarkov.Seed()
 	for i := 0; i < 10; i++ {
 		fmt.Println(myMarkov.GenerateSentence(999))
 	}
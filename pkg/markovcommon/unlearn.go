@@ -0,0 +1,95 @@
+package markovcommon
+
+import (
+	"errors"
+	"slices"
+)
+
+// unlearn.go
+// Author: Daniel Hannon
+// Version: 1
+// Brief: Taking a message back out of the chain, e.g. when it gets edited or deleted
+
+// Unlearner is implemented by chains that can undo AddStringToData
+type Unlearner interface {
+	RemoveStringFromData(input string) error
+	RemoveStringFromAuthor(author string, input string) error
+}
+
+// RemoveStringFromData takes away one count of every edge the string would add
+// Edges the chain doesn't have are skipped, so removing something that was never added does nothing
+func (md *MarkovData) RemoveStringFromData(input string) error {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	_, err := md.removeString(input)
+	return err
+}
+
+// RemoveStringFromAuthor works like RemoveStringFromData but also takes the edges off the author
+func (md *MarkovData) RemoveStringFromAuthor(author string, input string) error {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	edges, err := md.removeString(input)
+	if err != nil {
+		return err
+	}
+	md.unattributeEdges(hashID(author), edges)
+	return nil
+}
+
+// unattributeEdges takes edges off an author, the caller must hold the write lock
+func (md *MarkovData) unattributeEdges(key uint64, edges []edge) {
+	graph, ok := md.AuthorGraph[key]
+	if !ok {
+		return
+	}
+	for _, e := range edges {
+		if graph[e.From][e.To] <= 1 {
+			delete(graph[e.From], e.To)
+			if len(graph[e.From]) == 0 {
+				delete(graph, e.From)
+			}
+			continue
+		}
+		graph[e.From][e.To]--
+	}
+	if len(graph) == 0 {
+		delete(md.AuthorGraph, key)
+	}
+}
+
+// removeString does the work for RemoveStringFromData and hands back the edges it removed
+// The caller must hold the write lock
+func (md *MarkovData) removeString(input string) ([]edge, error) {
+	if input == "" {
+		return nil, errors.New("nothing passed, nothing to do")
+	}
+	md.initialise()
+
+	removed := []edge{}
+	for _, e := range md.findEdges(input) {
+		if md.WordGraph[e.From][e.To] == 0 {
+			continue
+		}
+		md.decrementEdge(e.From, e.To, 1)
+		removed = append(removed, e)
+	}
+	md.dropStartWords(removed)
+	return removed, nil
+}
+
+// dropStartWords takes words out of StartWords when nothing starts a sentence with them anymore
+func (md *MarkovData) dropStartWords(removed []edge) {
+	start, ok := md.WordRef["§"]
+	if !ok {
+		return
+	}
+	for _, e := range removed {
+		if e.From != start {
+			continue
+		}
+		if _, ok := md.WordGraph[start][e.To]; !ok {
+			md.StartWords = slices.DeleteFunc(md.StartWords, func(val uint) bool { return val == e.To })
+		}
+	}
+}
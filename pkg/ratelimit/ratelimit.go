@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting
+// Author Daniel Hannon
+// Version 1
+// Brief: Token buckets per guild, channel and user, so the bot can't be made to spam or burn through API quotas
+
+var ErrInvalidLimit = errors.New("limits look like 3/1m")
+
+// Limit lets Count things happen per Per, all at once if they've been saved up
+// The zero value is no limit
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseLimit reads a limit written like "3/1m", "none" or "" is no limit
+func ParseLimit(input string) (Limit, error) {
+	if input == "" || input == "none" {
+		return Limit{}, nil
+	}
+	count, per, ok := strings.Cut(input, "/")
+	if !ok {
+		return Limit{}, ErrInvalidLimit
+	}
+	c, err := strconv.Atoi(count)
+	if err != nil || c <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	p, err := time.ParseDuration(per)
+	if err != nil || p <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	return Limit{Count: c, Per: p}, nil
+}
+
+func (l Limit) String() string {
+	if l.Count == 0 {
+		return "none"
+	}
+	return strconv.Itoa(l.Count) + "/" + l.Per.String()
+}
+
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Limit) UnmarshalText(data []byte) error {
+	limit, err := ParseLimit(string(data))
+	if err != nil {
+		return err
+	}
+	*l = limit
+	return nil
+}
+
+// Scope is what a bucket is shared between
+type Scope int
+
+const (
+	ScopeNone Scope = iota
+	ScopeGuild
+	ScopeChannel
+	ScopeUser
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeGuild:
+		return "guild"
+	case ScopeChannel:
+		return "channel"
+	case ScopeUser:
+		return "user"
+	}
+	return "none"
+}
+
+// Rule is the limits for one action, an action is only allowed if every scope has room
+type Rule struct {
+	Guild   Limit `json:"Guild,omitempty"`
+	Channel Limit `json:"Channel,omitempty"`
+	User    Limit `json:"User,omitempty"`
+}
+
+// Result says whether an action was allowed, and if not how long until it would be
+type Result struct {
+	Allowed bool
+	Wait    time.Duration // How long until there's room
+	Scope   Scope         // Which scope ran out
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// refill tops the bucket up for the time that's passed
+func (b *bucket) refill(now time.Time) {
+	rate := float64(b.limit.Count) / float64(b.limit.Per)
+	b.tokens = min(float64(b.limit.Count), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+}
+
+// wait is how long until the bucket has a whole token
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.limit.Per) / float64(b.limit.Count))
+}
+
+// sweepEvery is how many calls to Allow there are between clearing out full buckets
+const sweepEvery = 1000
+
+type Limiter struct {
+	rules   map[string]Rule
+	buckets map[string]*bucket
+	calls   int
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+// New creates a limiter with the rules for each action
+func New(rules map[string]Rule) *Limiter {
+	return &Limiter{
+		rules:   rules,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Rule gets the rule for an action
+func (l *Limiter) Rule(action string) (Rule, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rule, ok := l.rules[action]
+	return rule, ok
+}
+
+// Allow checks if an action can happen and uses up a token from each scope if it can
+// Actions without a rule are always allowed
+func (l *Limiter) Allow(action string, guildId string, channelId string, userId string) Result {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rule, ok := l.rules[action]
+	if !ok {
+		return Result{Allowed: true}
+	}
+	now := l.now()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	scopes := []struct {
+		scope Scope
+		limit Limit
+		key   string
+	}{
+		{ScopeGuild, rule.Guild, action + "/guild/" + guildId},
+		{ScopeChannel, rule.Channel, action + "/channel/" + channelId},
+		{ScopeUser, rule.User, action + "/user/" + guildId + "/" + userId},
+	}
+	result := Result{Allowed: true}
+	using := []*bucket{}
+	for _, s := range scopes {
+		if s.limit.Count == 0 {
+			continue
+		}
+		b, ok := l.buckets[s.key]
+		if !ok || b.limit != s.limit {
+			b = &bucket{tokens: float64(s.limit.Count), last: now, limit: s.limit}
+			l.buckets[s.key] = b
+		}
+		b.refill(now)
+		if wait := b.wait(); wait > 0 {
+			result.Allowed = false
+			if wait > result.Wait {
+				result.Wait = wait
+				result.Scope = s.scope
+			}
+		}
+		using = append(using, b)
+	}
+	if result.Allowed {
+		for _, b := range using {
+			b.tokens--
+		}
+	}
+	return result
+}
+
+// sweep drops buckets that have filled back up, they're the same as new ones
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Count) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("3/1m")
+	if err != nil || limit.Count != 3 || limit.Per != time.Minute {
+		t.Fatal("Expected 3 a minute, got", limit, err)
+	}
+	for _, bad := range []string{"3", "0/1m", "x/1m", "3/soon", "3/-1s"} {
+		if _, err := ParseLimit(bad); err != ErrInvalidLimit {
+			t.Error("Expected", bad, "to be invalid")
+		}
+	}
+
+	rule := Rule{User: Limit{2, 30 * time.Second}}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := Rule{}
+	if err := json.Unmarshal(data, &parsed); err != nil || parsed != rule {
+		t.Fatal("Rule didn't survive JSON:", string(data), err)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	l := New(map[string]Rule{
+		"bark": {Channel: Limit{3, time.Minute}, User: Limit{2, time.Minute}},
+	})
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("unlimited", "g", "c", "u").Allowed {
+		t.Fatal("Actions without a rule should always be allowed")
+	}
+
+	// Two from one user, then they're out
+	for i := 0; i < 2; i++ {
+		if !l.Allow("bark", "g", "c", "alice").Allowed {
+			t.Fatal("Expected alice's bark", i, "to be allowed")
+		}
+	}
+	res := l.Allow("bark", "g", "c", "alice")
+	if res.Allowed || res.Scope != ScopeUser || res.Wait != 30*time.Second {
+		t.Fatal("Expected alice to wait 30s, got", res)
+	}
+
+	// Someone else can go once before the channel runs out
+	if !l.Allow("bark", "g", "c", "bob").Allowed {
+		t.Fatal("Expected bob to be allowed")
+	}
+	res = l.Allow("bark", "g", "c", "bob")
+	if res.Allowed || res.Scope != ScopeChannel || res.Wait != 20*time.Second {
+		t.Fatal("Expected the channel to be out for 20s, got", res)
+	}
+	// Being refused doesn't use anything up, so bob's still fine in another channel
+	if !l.Allow("bark", "g", "other", "bob").Allowed {
+		t.Fatal("Expected bob to be allowed in another channel")
+	}
+
+	now = now.Add(20 * time.Second)
+	if !l.Allow("bark", "g", "c", "carol").Allowed {
+		t.Fatal("Expected the channel to have refilled a token")
+	}
+
+	// Everything fills back up and gets swept
+	now = now.Add(time.Hour)
+	l.sweep(now)
+	if len(l.buckets) != 0 {
+		t.Fatal("Expected full buckets to be swept, got", len(l.buckets))
+	}
+}
@@ -0,0 +1,265 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Argument definitions and parsing for both kinds of command
+
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgBool
+	ArgUser
+	ArgChannel
+	ArgRole
+	ArgAttachment // A file, prefix commands take these from the message's attachments in order
+)
+
+// AutocompleteFunc suggests values for an argument based on what's been typed so far
+type AutocompleteFunc func(ctx *Context, typed string) []*discordgo.ApplicationCommandOptionChoice
+
+type Arg struct {
+	Name         string           // Name of the argument, lower case for slash commands
+	Description  string           // Shown in the slash command list
+	Type         ArgType          // What kind of value it takes
+	Required     bool             // Command won't run without it
+	Min          *int64           // Lowest value allowed for ArgInt
+	Max          *int64           // Highest value allowed for ArgInt
+	Choices      []string         // Only these values are allowed for ArgString
+	Rest         bool             // ArgString only, takes the rest of the message for prefix commands
+	Autocomplete AutocompleteFunc // Suggestions for slash commands
+}
+
+// Bound is a helper for setting Min and Max
+func Bound(val int64) *int64 {
+	return &val
+}
+
+func (a *Arg) usage() string {
+	name := a.Name
+	if len(a.Choices) > 0 {
+		name = strings.Join(a.Choices, "|")
+	}
+	if a.Required {
+		return "<" + name + ">"
+	}
+	return "[" + name + "]"
+}
+
+// option builds the slash command option for an argument
+func (a *Arg) option() *discordgo.ApplicationCommandOption {
+	opt := &discordgo.ApplicationCommandOption{
+		Name:         a.Name,
+		Description:  a.Description,
+		Required:     a.Required,
+		Autocomplete: a.Autocomplete != nil,
+	}
+	switch a.Type {
+	case ArgString:
+		opt.Type = discordgo.ApplicationCommandOptionString
+		for _, choice := range a.Choices {
+			opt.Choices = append(opt.Choices, &discordgo.ApplicationCommandOptionChoice{Name: choice, Value: choice})
+		}
+	case ArgInt:
+		opt.Type = discordgo.ApplicationCommandOptionInteger
+		if a.Min != nil {
+			val := float64(*a.Min)
+			opt.MinValue = &val
+		}
+		if a.Max != nil {
+			opt.MaxValue = float64(*a.Max)
+		}
+	case ArgBool:
+		opt.Type = discordgo.ApplicationCommandOptionBoolean
+	case ArgUser:
+		opt.Type = discordgo.ApplicationCommandOptionUser
+	case ArgChannel:
+		opt.Type = discordgo.ApplicationCommandOptionChannel
+	case ArgRole:
+		opt.Type = discordgo.ApplicationCommandOptionRole
+	case ArgAttachment:
+		opt.Type = discordgo.ApplicationCommandOptionAttachment
+	}
+	return opt
+}
+
+var (
+	userMention    = regexp.MustCompile(`^<@!?([0-9]+)>$`)
+	channelMention = regexp.MustCompile(`^<#([0-9]+)>$`)
+	roleMention    = regexp.MustCompile(`^<@&([0-9]+)>$`)
+	snowflake      = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// splitArgs splits a command up on whitespace, anything in double quotes is kept together
+func splitArgs(input string) []string {
+	output := []string{}
+	var current strings.Builder
+	quoted := false
+	inField := false
+	for _, r := range input {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if inField {
+				output = append(output, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		output = append(output, current.String())
+	}
+	return output
+}
+
+// parseMention pulls the ID out of a mention, plain IDs are accepted too
+func parseMention(input string, mention *regexp.Regexp) (string, bool) {
+	if match := mention.FindStringSubmatch(input); match != nil {
+		return match[1], true
+	}
+	if snowflake.MatchString(input) {
+		return input, true
+	}
+	return "", false
+}
+
+// parseValue converts a single prefix command argument
+func (a *Arg) parseValue(raw string, msg *discordgo.Message) (any, error) {
+	invalid := fmt.Errorf("%w: %s should be %s", ErrInvalidArg, a.Name, a.usage())
+	switch a.Type {
+	case ArgInt:
+		val, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s should be a number", ErrInvalidArg, a.Name)
+		}
+		if (a.Min != nil && val < *a.Min) || (a.Max != nil && val > *a.Max) {
+			return nil, fmt.Errorf("%w: %s is out of range", ErrInvalidArg, a.Name)
+		}
+		return val, nil
+	case ArgBool:
+		switch strings.ToLower(raw) {
+		case "on", "true", "yes", "enable":
+			return true, nil
+		case "off", "false", "no", "disable":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%w: %s should be on or off", ErrInvalidArg, a.Name)
+	case ArgUser:
+		id, ok := parseMention(raw, userMention)
+		if !ok {
+			return nil, invalid
+		}
+		for _, user := range msg.Mentions {
+			if user.ID == id {
+				return user, nil
+			}
+		}
+		return &discordgo.User{ID: id}, nil
+	case ArgChannel:
+		if id, ok := parseMention(raw, channelMention); ok {
+			return id, nil
+		}
+		return nil, invalid
+	case ArgRole:
+		if id, ok := parseMention(raw, roleMention); ok {
+			return id, nil
+		}
+		return nil, invalid
+	}
+	if len(a.Choices) > 0 {
+		for _, choice := range a.Choices {
+			if strings.EqualFold(choice, raw) {
+				return choice, nil
+			}
+		}
+		return nil, invalid
+	}
+	return raw, nil
+}
+
+// parseMessageArgs matches up the words after a prefix command with its arguments
+func parseMessageArgs(cmd *Command, fields []string, msg *discordgo.Message) (map[string]any, error) {
+	output := map[string]any{}
+	idx, attached := 0, 0
+	for _, arg := range cmd.Args {
+		if arg.Type == ArgAttachment {
+			if attached < len(msg.Attachments) {
+				output[arg.Name] = msg.Attachments[attached]
+				attached++
+			} else if arg.Required {
+				return nil, fmt.Errorf("%w: %s, attach it to the message", ErrMissingArg, arg.Name)
+			}
+			continue
+		}
+		if idx >= len(fields) {
+			if arg.Required {
+				return nil, fmt.Errorf("%w: %s, usage is %s", ErrMissingArg, arg.Name, cmd.Usage(""))
+			}
+			continue
+		}
+		raw := fields[idx]
+		if arg.Rest && arg.Type == ArgString {
+			raw = strings.Join(fields[idx:], " ")
+		}
+		val, err := arg.parseValue(raw, msg)
+		if err != nil {
+			return nil, err
+		}
+		output[arg.Name] = val
+		idx++
+	}
+	return output, nil
+}
+
+// optionString gets the raw value of an option as a string, autocomplete sends partial values this way
+func optionString(opt *discordgo.ApplicationCommandInteractionDataOption) string {
+	if str, ok := opt.Value.(string); ok {
+		return str
+	}
+	return fmt.Sprint(opt.Value)
+}
+
+// parseInteractionArgs pulls the options out of a slash command
+// Discord has already checked the types, except for whatever's being autocompleted
+func parseInteractionArgs(data discordgo.ApplicationCommandInteractionData) map[string]any {
+	output := map[string]any{}
+	for _, opt := range data.Options {
+		if opt.Focused {
+			output[opt.Name] = optionString(opt)
+			continue
+		}
+		switch opt.Type {
+		case discordgo.ApplicationCommandOptionInteger:
+			output[opt.Name] = opt.IntValue()
+		case discordgo.ApplicationCommandOptionBoolean:
+			output[opt.Name] = opt.BoolValue()
+		case discordgo.ApplicationCommandOptionUser:
+			id := optionString(opt)
+			output[opt.Name] = &discordgo.User{ID: id}
+			if data.Resolved != nil && data.Resolved.Users[id] != nil {
+				output[opt.Name] = data.Resolved.Users[id]
+			}
+		case discordgo.ApplicationCommandOptionAttachment:
+			if data.Resolved != nil && data.Resolved.Attachments[optionString(opt)] != nil {
+				output[opt.Name] = data.Resolved.Attachments[optionString(opt)]
+			}
+		default:
+			output[opt.Name] = optionString(opt)
+		}
+	}
+	return output
+}
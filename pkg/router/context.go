@@ -0,0 +1,158 @@
+package router
+
+import (
+	"errors"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Context is everything a handler needs to know about the command it's running
+// It hides whether the command came in as a message or a slash command
+
+type Context struct {
+	Session     *discordgo.Session
+	GuildID     string
+	ChannelID   string
+	Author      *discordgo.User              // Who ran the command
+	Member      *discordgo.Member            // Same but with guild info, can be nil for prefix commands
+	Message     *discordgo.MessageCreate     // Set for prefix commands
+	Interaction *discordgo.InteractionCreate // Set for slash commands
+	Command     *Command                     // The command being run
+	Prefix      string                       // Prefix used to run the command, "/" for slash commands
+	args        map[string]any
+	responded   bool // Interaction has already been responded to
+	deferred    bool // Interaction response was deferred and needs editing
+}
+
+// StringArg gets a string, channel or role argument, returning "" if it wasn't passed
+func (ctx *Context) StringArg(name string) string {
+	val, _ := ctx.args[name].(string)
+	return val
+}
+
+// IntArg gets an integer argument
+func (ctx *Context) IntArg(name string) (int64, bool) {
+	val, ok := ctx.args[name].(int64)
+	return val, ok
+}
+
+// BoolArg gets a boolean argument
+func (ctx *Context) BoolArg(name string) (bool, bool) {
+	val, ok := ctx.args[name].(bool)
+	return val, ok
+}
+
+// UserArg gets a user argument, only the ID is guaranteed to be filled in
+func (ctx *Context) UserArg(name string) *discordgo.User {
+	val, _ := ctx.args[name].(*discordgo.User)
+	return val
+}
+
+// AttachmentArg gets an attached file argument
+func (ctx *Context) AttachmentArg(name string) *discordgo.MessageAttachment {
+	val, _ := ctx.args[name].(*discordgo.MessageAttachment)
+	return val
+}
+
+// Permissions works out the permissions the person running the command has in the channel
+func (ctx *Context) Permissions() (int64, error) {
+	if ctx.Interaction != nil && ctx.Member != nil {
+		return ctx.Member.Permissions, nil
+	}
+	return ctx.Session.UserChannelPermissions(ctx.Author.ID, ctx.ChannelID)
+}
+
+// deferReply lets discord know the reply to a slash command is going to take a while
+func (ctx *Context) deferReply() {
+	if ctx.Interaction == nil || ctx.responded {
+		return
+	}
+	ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	ctx.responded = true
+	ctx.deferred = true
+}
+
+// respond answers a slash command, editing the deferred response if there is one
+func (ctx *Context) respond(data *discordgo.InteractionResponseData) error {
+	if ctx.deferred {
+		edit := &discordgo.WebhookEdit{Content: &data.Content, Files: data.Files}
+		if len(data.Embeds) > 0 {
+			edit.Embeds = &data.Embeds
+		}
+		ctx.deferred = false
+		_, err := ctx.Session.InteractionResponseEdit(ctx.Interaction.Interaction, edit)
+		return err
+	}
+	if ctx.responded {
+		_, err := ctx.Session.FollowupMessageCreate(ctx.Interaction.Interaction, false, &discordgo.WebhookParams{
+			Content: data.Content,
+			Embeds:  data.Embeds,
+			Files:   data.Files,
+			Flags:   data.Flags,
+		})
+		return err
+	}
+	ctx.responded = true
+	return ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+}
+
+// Reply sends a message back to wherever the command came from
+// Slash commands always need an answer so an empty reply just says it's done
+func (ctx *Context) Reply(msg string) error {
+	if ctx.Interaction != nil {
+		if msg == "" {
+			if ctx.responded && !ctx.deferred {
+				return nil
+			}
+			return ctx.respond(&discordgo.InteractionResponseData{Content: "Done.", Flags: discordgo.MessageFlagsEphemeral})
+		}
+		return ctx.respond(&discordgo.InteractionResponseData{Content: msg})
+	}
+	if msg == "" {
+		return nil
+	}
+	_, err := ctx.Session.ChannelMessageSend(ctx.ChannelID, msg)
+	return err
+}
+
+// ReplyFile sends a message with a file attached, the handler should return an empty reply after
+func (ctx *Context) ReplyFile(msg string, file *discordgo.File) error {
+	if ctx.Interaction != nil {
+		return ctx.respond(&discordgo.InteractionResponseData{Content: msg, Files: []*discordgo.File{file}})
+	}
+	_, err := ctx.Session.ChannelMessageSendComplex(ctx.ChannelID, &discordgo.MessageSend{Content: msg, Files: []*discordgo.File{file}})
+	return err
+}
+
+// ReplyEmbed sends an embed back, the handler should return an empty reply after
+func (ctx *Context) ReplyEmbed(embed *discordgo.MessageEmbed) error {
+	if ctx.Interaction != nil {
+		return ctx.respond(&discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}})
+	}
+	_, err := ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, embed)
+	return err
+}
+
+// Error tells the person who ran the command that something went wrong
+// Slash commands get an ephemeral message so nobody else sees it
+func (ctx *Context) Error(err error) error {
+	if quiet := (quietError{}); errors.As(err, &quiet) && ctx.Interaction == nil {
+		return nil
+	}
+	msg := "Error: " + err.Error()
+	if ctx.Interaction != nil {
+		return ctx.respond(&discordgo.InteractionResponseData{Content: msg, Flags: discordgo.MessageFlagsEphemeral})
+	}
+	_, sendErr := ctx.Session.ChannelMessageSendReply(ctx.ChannelID, msg, ctx.Message.Reference())
+	return sendErr
+}
+
+// Handled marks a slash command as answered when a handler has replied some other way
+func (ctx *Context) Handled() {
+	ctx.responded = true
+}
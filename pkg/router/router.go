@@ -0,0 +1,329 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command router
+// Author Daniel Hannon
+// Version 1
+// Brief: One registry of commands that serves both prefix commands and slash commands
+
+var (
+	ErrForbidden  = errors.New("you don't have permission to do that")
+	ErrMissingArg = errors.New("missing argument")
+	ErrInvalidArg = errors.New("invalid argument")
+)
+
+// quietError is an error prefix commands don't reply with, see Quiet
+type quietError struct{ error }
+
+func (qe quietError) Unwrap() error { return qe.error }
+
+// Quiet wraps an error so a prefix command fails without a reply, like when replying would be spam in itself
+// Slash commands still show it since they always need an answer, and only the person who ran it sees that
+func Quiet(err error) error {
+	return quietError{err}
+}
+
+// Handler runs a command, whatever string it returns is sent back as the reply
+// Errors are shown to the person who ran the command so they need to be readable
+type Handler func(ctx *Context) (string, error)
+
+// Check is a requirement that has to pass before a command runs, e.g. being in the right channel
+type Check func(ctx *Context) error
+
+// Authorizer decides if someone is allowed to run a command
+type Authorizer func(ctx *Context, cmd *Command) error
+
+type Command struct {
+	Name        string  // What the command is called, without the prefix
+	Description string  // Shown in the help text and the slash command list
+	Args        []*Arg  // Arguments in the order they're given to prefix commands
+	Permissions int64   // Discord permissions needed to run the command, 0 for everyone
+	Checks      []Check // Anything else that needs to pass first
+	Slow        bool    // Handler might take more than 3 seconds, so slash replies are deferred
+	Hidden      bool    // Left out of the help text
+	Handler     Handler // Does the actual work
+}
+
+// Usage is how the command is typed out with the given prefix
+func (c *Command) Usage(prefix string) string {
+	output := prefix + c.Name
+	for _, arg := range c.Args {
+		output += " " + arg.usage()
+	}
+	return output
+}
+
+type Router struct {
+	Prefix    string      // Prefix for message commands
+	Authorize Authorizer  // Permission check, DefaultAuthorizer if nil
+	Logger    *log.Logger // Where rejected and failed commands get logged
+	Checks    []Check     // Run for every command after its own checks, cooldowns and the like
+	// Hide slash commands in discord from anyone without their permissions
+	// Leave this off if Authorize lets other people run them, otherwise they can't see them
+	HideRestricted bool
+	commands       map[string]*Command
+	order          []string
+}
+
+// New creates a router for the given prefix
+func New(prefix string, logger *log.Logger) *Router {
+	if logger == nil {
+		logger = log.New(log.Writer(), "[Router] ", log.LstdFlags|log.Lmicroseconds|log.Lmsgprefix|log.Lshortfile)
+	}
+	return &Router{
+		Prefix:   prefix,
+		Logger:   logger,
+		commands: map[string]*Command{},
+		order:    []string{},
+	}
+}
+
+// Register adds commands to the router, a command with the same name as an existing one replaces it
+func (r *Router) Register(cmds ...*Command) {
+	for _, cmd := range cmds {
+		if _, ok := r.commands[cmd.Name]; !ok {
+			r.order = append(r.order, cmd.Name)
+		}
+		r.commands[cmd.Name] = cmd
+	}
+}
+
+// Get looks up a command by name
+func (r *Router) Get(name string) (*Command, bool) {
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// Commands lists every registered command in the order they were registered
+func (r *Router) Commands() []*Command {
+	output := make([]*Command, 0, len(r.order))
+	for _, name := range r.order {
+		output = append(output, r.commands[name])
+	}
+	return output
+}
+
+// Help generates the help text for every command that isn't hidden
+func (r *Router) Help(prefix string) string {
+	usages := []string{}
+	width := 0
+	cmds := slices.DeleteFunc(r.Commands(), func(cmd *Command) bool { return cmd.Hidden })
+	for _, cmd := range cmds {
+		usage := cmd.Usage(prefix)
+		usages = append(usages, usage)
+		width = max(width, len(usage))
+	}
+	output := "```"
+	for idx, cmd := range cmds {
+		output += usages[idx] + strings.Repeat(" ", width-len(usages[idx])+2) + cmd.Description + "\n"
+	}
+	return strings.TrimSuffix(output, "\n") + "```"
+}
+
+// permissionNames are the names discord shows for the permissions commands are likely to need
+var permissionNames = []struct {
+	perm int64
+	name string
+}{
+	{discordgo.PermissionAdministrator, "Administrator"},
+	{discordgo.PermissionManageServer, "Manage Server"},
+	{discordgo.PermissionManageChannels, "Manage Channels"},
+	{discordgo.PermissionManageRoles, "Manage Roles"},
+	{discordgo.PermissionManageMessages, "Manage Messages"},
+	{discordgo.PermissionKickMembers, "Kick Members"},
+	{discordgo.PermissionBanMembers, "Ban Members"},
+}
+
+// PermissionNames lists the permissions in a bitset by name, e.g. "Manage Server and Manage Roles"
+func PermissionNames(perms int64) string {
+	names := []string{}
+	for _, p := range permissionNames {
+		if perms&p.perm != 0 {
+			names = append(names, p.name)
+			perms &^= p.perm
+		}
+	}
+	if perms != 0 {
+		names = append(names, fmt.Sprintf("permissions %#x", perms))
+	}
+	return strings.Join(names, " and ")
+}
+
+// HasPermissions checks if the person running the command has all of perms (or is an administrator)
+func HasPermissions(ctx *Context, perms int64) (bool, error) {
+	have, err := ctx.Permissions()
+	if err != nil {
+		return false, err
+	}
+	return have&discordgo.PermissionAdministrator != 0 || have&perms == perms, nil
+}
+
+// DefaultAuthorizer only lets people with all of a command's permissions (or administrators) run it
+func DefaultAuthorizer(ctx *Context, cmd *Command) error {
+	if cmd.Permissions == 0 {
+		return nil
+	}
+	ok, err := HasPermissions(ctx, cmd.Permissions)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w, %s needs %s", ErrForbidden, cmd.Name, PermissionNames(cmd.Permissions))
+	}
+	return nil
+}
+
+// run does the permission checks and runs a command
+func (r *Router) run(ctx *Context) {
+	authorize := r.Authorize
+	if authorize == nil {
+		authorize = DefaultAuthorizer
+	}
+	if err := authorize(ctx, ctx.Command); err != nil {
+		r.Logger.Println("Rejected", ctx.Command.Name, "from user", ctx.Author.ID, "in guild", ctx.GuildID, "reason:", err.Error())
+		ctx.Error(err)
+		return
+	}
+	for _, check := range append(slices.Clip(ctx.Command.Checks), r.Checks...) {
+		if err := check(ctx); err != nil {
+			ctx.Error(err)
+			return
+		}
+	}
+	if ctx.Command.Slow {
+		ctx.deferReply()
+	}
+	msg, err := ctx.Command.Handler(ctx)
+	if err != nil {
+		r.Logger.Println("Command", ctx.Command.Name, "failed for user", ctx.Author.ID, "in guild", ctx.GuildID, "reason:", err.Error())
+		ctx.Error(err)
+		return
+	}
+	ctx.Reply(msg)
+}
+
+// HandleMessage runs a prefix command, it returns true if the message was meant for the router
+func (r *Router) HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate) bool {
+	if r.Prefix == "" || !strings.HasPrefix(m.Content, r.Prefix) {
+		return false
+	}
+	fields := splitArgs(strings.TrimPrefix(m.Content, r.Prefix))
+	if len(fields) == 0 {
+		return true
+	}
+	cmd, ok := r.commands[strings.ToLower(fields[0])]
+	if !ok {
+		return true
+	}
+	ctx := &Context{
+		Session:   s,
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		Author:    m.Author,
+		Member:    m.Member,
+		Message:   m,
+		Command:   cmd,
+		Prefix:    r.Prefix,
+	}
+	args, err := parseMessageArgs(cmd, fields[1:], m.Message)
+	if err != nil {
+		ctx.Error(err)
+		return true
+	}
+	ctx.args = args
+	r.run(ctx)
+	return true
+}
+
+// HandleInteraction runs slash commands and answers autocomplete requests
+func (r *Router) HandleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand && i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+		return
+	}
+	if i.Member == nil {
+		return
+	}
+	data := i.ApplicationCommandData()
+	cmd, ok := r.commands[data.Name]
+	if !ok {
+		return
+	}
+	ctx := &Context{
+		Session:     s,
+		GuildID:     i.GuildID,
+		ChannelID:   i.ChannelID,
+		Author:      i.Member.User,
+		Member:      i.Member,
+		Interaction: i,
+		Command:     cmd,
+		Prefix:      "/",
+		args:        parseInteractionArgs(data),
+	}
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		r.autocomplete(ctx, data)
+		return
+	}
+	r.run(ctx)
+}
+
+// autocomplete answers an autocomplete request for whichever option is focused
+func (r *Router) autocomplete(ctx *Context, data discordgo.ApplicationCommandInteractionData) {
+	for _, opt := range data.Options {
+		if !opt.Focused {
+			continue
+		}
+		for _, arg := range ctx.Command.Args {
+			if arg.Name != opt.Name || arg.Autocomplete == nil {
+				continue
+			}
+			choices := arg.Autocomplete(ctx, strings.TrimSpace(optionString(opt)))
+			if len(choices) > 25 {
+				choices = choices[:25]
+			}
+			err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+				Data: &discordgo.InteractionResponseData{Choices: choices},
+			})
+			if err != nil {
+				r.Logger.Println("Non-Fatal Error:", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// ApplicationCommands builds the slash command definitions for every registered command
+func (r *Router) ApplicationCommands() []*discordgo.ApplicationCommand {
+	output := []*discordgo.ApplicationCommand{}
+	for _, cmd := range r.Commands() {
+		appCmd := &discordgo.ApplicationCommand{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Options:     []*discordgo.ApplicationCommandOption{},
+		}
+		if r.HideRestricted && cmd.Permissions != 0 {
+			perms := cmd.Permissions
+			appCmd.DefaultMemberPermissions = &perms
+		}
+		for _, arg := range cmd.Args {
+			appCmd.Options = append(appCmd.Options, arg.option())
+		}
+		output = append(output, appCmd)
+	}
+	return output
+}
+
+// Sync registers the slash commands for a guild, anything that isn't registered with the router gets removed
+func (r *Router) Sync(s *discordgo.Session, appId string, guildId string) error {
+	_, err := s.ApplicationCommandBulkOverwrite(appId, guildId, r.ApplicationCommands())
+	return err
+}
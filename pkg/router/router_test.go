@@ -0,0 +1,143 @@
+package router
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func noop(ctx *Context) (string, error) { return "", nil }
+
+func TestSplitArgs(t *testing.T) {
+	got := splitArgs(`say  "hello there"  world ""`)
+	want := []string{"say", "hello there", "world", ""}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %q got %q", want, got)
+	}
+}
+
+func TestParseMessageArgs(t *testing.T) {
+	cmd := &Command{
+		Name: "test",
+		Args: []*Arg{
+			{Name: "rate", Type: ArgInt, Required: true, Min: Bound(0), Max: Bound(100)},
+			{Name: "user", Type: ArgUser},
+			{Name: "text", Type: ArgString, Rest: true},
+		},
+		Handler: noop,
+	}
+	msg := &discordgo.Message{Mentions: []*discordgo.User{{ID: "1234", Username: "someone"}}}
+
+	args, err := parseMessageArgs(cmd, []string{"50", "<@!1234>", "the", "rest"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args["rate"] != int64(50) {
+		t.Error("expected rate 50 got", args["rate"])
+	}
+	if user, _ := args["user"].(*discordgo.User); user == nil || user.Username != "someone" {
+		t.Error("expected the mentioned user got", args["user"])
+	}
+	if args["text"] != "the rest" {
+		t.Error("expected the rest of the message got", args["text"])
+	}
+
+	if _, err := parseMessageArgs(cmd, []string{"101"}, msg); !errors.Is(err, ErrInvalidArg) {
+		t.Error("expected out of range to be invalid, got", err)
+	}
+	if _, err := parseMessageArgs(cmd, []string{"ten"}, msg); !errors.Is(err, ErrInvalidArg) {
+		t.Error("expected a word to be an invalid number, got", err)
+	}
+	if _, err := parseMessageArgs(cmd, []string{}, msg); !errors.Is(err, ErrMissingArg) {
+		t.Error("expected missing argument, got", err)
+	}
+	if _, err := parseMessageArgs(cmd, []string{"5", "nobody"}, msg); !errors.Is(err, ErrInvalidArg) {
+		t.Error("expected a bad mention to be invalid, got", err)
+	}
+
+	// Attachments come off the message and don't use up any words
+	upload := &Command{
+		Name: "upload",
+		Args: []*Arg{
+			{Name: "file", Type: ArgAttachment, Required: true},
+			{Name: "mode", Choices: []string{"replace", "merge"}, Required: true},
+		},
+		Handler: noop,
+	}
+	if _, err := parseMessageArgs(upload, []string{"merge"}, msg); !errors.Is(err, ErrMissingArg) {
+		t.Error("expected a missing attachment, got", err)
+	}
+	msg.Attachments = []*discordgo.MessageAttachment{{Filename: "brain.zip"}}
+	args, err = parseMessageArgs(upload, []string{"merge"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, _ := args["file"].(*discordgo.MessageAttachment); file == nil || file.Filename != "brain.zip" || args["mode"] != "merge" {
+		t.Error("expected the attachment and mode, got", args)
+	}
+}
+
+func TestHelpAndApplicationCommands(t *testing.T) {
+	r := New("!", nil)
+	r.Register(
+		&Command{Name: "bark", Description: "Say something", Handler: noop},
+		&Command{
+			Name:        "adjustrate",
+			Description: "Change the odds",
+			Permissions: discordgo.PermissionManageServer,
+			Args:        []*Arg{{Name: "rate", Description: "Odds", Type: ArgInt, Required: true, Min: Bound(0), Max: Bound(100)}},
+			Handler:     noop,
+		},
+		&Command{Name: "secret", Description: "Hidden", Hidden: true, Handler: noop},
+	)
+
+	help := r.Help("!")
+	if !strings.Contains(help, "!bark               Say something") || !strings.Contains(help, "!adjustrate <rate>  Change the odds") {
+		t.Error("unexpected help text:", help)
+	}
+	if strings.Contains(help, "secret") {
+		t.Error("hidden command shown in help")
+	}
+
+	if r.ApplicationCommands()[1].DefaultMemberPermissions != nil {
+		t.Error("restricted commands should only be hidden when asked")
+	}
+	r.HideRestricted = true
+	cmds := r.ApplicationCommands()
+	if len(cmds) != 3 {
+		t.Fatal("expected 3 application commands got", len(cmds))
+	}
+	if cmds[0].DefaultMemberPermissions != nil {
+		t.Error("bark shouldn't need permissions")
+	}
+	if cmds[1].DefaultMemberPermissions == nil || *cmds[1].DefaultMemberPermissions != discordgo.PermissionManageServer {
+		t.Error("adjustrate should need manage server")
+	}
+	opt := cmds[1].Options[0]
+	if opt.Type != discordgo.ApplicationCommandOptionInteger || *opt.MinValue != 0 || opt.MaxValue != 100 || !opt.Required {
+		t.Error("rate option built wrong:", opt)
+	}
+}
+
+func TestPermissionNames(t *testing.T) {
+	if got := PermissionNames(discordgo.PermissionManageServer); got != "Manage Server" {
+		t.Error("expected Manage Server got", got)
+	}
+	if got := PermissionNames(discordgo.PermissionManageServer | discordgo.PermissionManageRoles); got != "Manage Server and Manage Roles" {
+		t.Error("expected Manage Server and Manage Roles got", got)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	err := Quiet(ErrForbidden)
+	if !errors.Is(err, ErrForbidden) || err.Error() != ErrForbidden.Error() {
+		t.Fatal("Quiet should still look like the error it wraps, got", err)
+	}
+	// A prefix command with a quiet error doesn't reply, so there's no session needed
+	if err := (&Context{}).Error(err); err != nil {
+		t.Error("Expected no reply, got", err)
+	}
+}
@@ -0,0 +1,146 @@
+package schedule
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron expressions
+// Author Daniel Hannon
+// Version 1
+// Brief: The usual five field cron expressions, minute hour day-of-month month day-of-week
+
+var ErrInvalidCron = errors.New("cron expressions look like \"0 9 * * 1-5\" (minute hour day month weekday) or @hourly, @daily, @weekly")
+
+// shorthands are the named expressions
+var shorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// field limits, in the order they're written
+var fieldBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+type Cron struct {
+	minute, hour, day, month, weekday uint64 // Bit n is set if n matches
+	anyDay, anyWeekday                bool   // Whether day or weekday were *, which changes how they combine
+	expr                              string
+}
+
+// ParseCron reads a cron expression
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	full := expr
+	if named, ok := shorthands[strings.ToLower(expr)]; ok {
+		full = named
+	}
+	fields := strings.Fields(full)
+	if len(fields) != 5 {
+		return nil, ErrInvalidCron
+	}
+	c := &Cron{expr: expr}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.day, &c.month, &c.weekday}
+	for idx, field := range fields {
+		set, err := parseField(field, fieldBounds[idx][0], fieldBounds[idx][1])
+		if err != nil {
+			return nil, err
+		}
+		*sets[idx] = set
+	}
+	// Sunday is 0 or 7
+	if c.weekday&(1<<7) != 0 {
+		c.weekday |= 1
+	}
+	c.anyDay = fields[2] == "*"
+	c.anyWeekday = fields[4] == "*"
+	return c, nil
+}
+
+// parseField reads one comma separated field of numbers, ranges and steps
+func parseField(field string, low int, high int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		every := 1
+		if hasStep {
+			var err error
+			if every, err = strconv.Atoi(step); err != nil || every <= 0 {
+				return 0, ErrInvalidCron
+			}
+		}
+		from, to := low, high
+		if rng != "*" {
+			start, end, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(start); err != nil {
+				return 0, ErrInvalidCron
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(end); err != nil {
+					return 0, ErrInvalidCron
+				}
+			} else if hasStep {
+				// 5/15 means from 5 onwards
+				to = high
+			}
+		}
+		if from < low || to > high || from > to {
+			return 0, ErrInvalidCron
+		}
+		for n := from; n <= to; n += every {
+			set |= 1 << n
+		}
+	}
+	return set, nil
+}
+
+func has(set uint64, n int) bool {
+	return set&(1<<n) != 0
+}
+
+// dayMatches follows cron's rule that if both day and weekday are given either one can match
+func (c *Cron) dayMatches(t time.Time) bool {
+	day, weekday := has(c.day, t.Day()), has(c.weekday, int(t.Weekday()))
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Matches checks if the minute t is in matches
+func (c *Cron) Matches(t time.Time) bool {
+	return has(c.minute, t.Minute()) && has(c.hour, t.Hour()) && has(c.month, int(t.Month())) && c.dayMatches(t)
+}
+
+// Next finds the first matching minute after t, in t's timezone
+// It gives up after a few years, for things like the 31st of February, and returns the zero time
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !has(c.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !has(c.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !has(c.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) String() string {
+	return c.expr
+}
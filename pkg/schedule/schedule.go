@@ -0,0 +1,142 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Scheduled posts
+// Author Daniel Hannon
+// Version 1
+// Brief: When the bot should post on its own, either on a cron schedule or after a channel's been quiet for a while
+
+var (
+	ErrUnknownTimezone = errors.New("unknown timezone, use one like Europe/Dublin")
+	ErrIdleTooShort    = errors.New("channels have to be idle for at least " + MinIdle.String())
+)
+
+var (
+	MinIdle     = 10 * time.Minute // Shortest idle time allowed, anything less is just spam
+	MissedGrace = 10 * time.Minute // How late a cron post can be and still go out, after that it's skipped
+)
+
+type Schedule struct {
+	ID        int           // Number used to remove it, unique within a server
+	ChannelID string        // Channel it posts in
+	Cron      string        // Cron expression, or empty for idle schedules
+	Timezone  string        // Timezone the cron expression is in, UTC if empty
+	Idle      time.Duration // How long the channel has to be quiet, for idle schedules
+	Last      time.Time     // When it last posted, or was made
+	cron      *Cron
+	location  *time.Location
+}
+
+// NewCron creates a schedule that posts whenever the expression matches
+func NewCron(channelId string, expr string, timezone string) (*Schedule, error) {
+	sch := &Schedule{ChannelID: channelId, Cron: expr, Timezone: timezone, Last: time.Now()}
+	if err := sch.parse(); err != nil {
+		return nil, err
+	}
+	return sch, nil
+}
+
+// NewIdle creates a schedule that posts when a channel has been quiet for a while
+func NewIdle(channelId string, idle time.Duration) (*Schedule, error) {
+	if idle < MinIdle {
+		return nil, ErrIdleTooShort
+	}
+	return &Schedule{ChannelID: channelId, Idle: idle, Last: time.Now()}, nil
+}
+
+// parse sets up the cron expression and timezone
+func (sch *Schedule) parse() error {
+	if sch.Cron == "" {
+		if sch.Idle < MinIdle {
+			return ErrIdleTooShort
+		}
+		return nil
+	}
+	cron, err := ParseCron(sch.Cron)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(sch.Timezone)
+	if err != nil {
+		return ErrUnknownTimezone
+	}
+	sch.cron, sch.location = cron, loc
+	return nil
+}
+
+// Next is when a cron schedule posts next, or the zero time for idle schedules
+func (sch *Schedule) Next() time.Time {
+	if sch.cron == nil {
+		return time.Time{}
+	}
+	return sch.cron.Next(sch.Last.In(sch.location))
+}
+
+// Check says if the schedule should post now, lastActivity being when anything was last said in the channel
+// It moves Last on when it's due or a cron post was missed by more than MissedGrace, like while the bot was down
+func (sch *Schedule) Check(now time.Time, lastActivity time.Time) bool {
+	if sch.cron == nil {
+		if now.Sub(lastActivity) < sch.Idle || now.Sub(sch.Last) < sch.Idle {
+			return false
+		}
+		sch.Last = now
+		return true
+	}
+	next := sch.Next()
+	if next.IsZero() || next.After(now) {
+		return false
+	}
+	sch.Last = now
+	return now.Sub(next) <= MissedGrace
+}
+
+func (sch *Schedule) String() string {
+	if sch.cron == nil {
+		return fmt.Sprintf("#%d in <#%s>: after %s without a message", sch.ID, sch.ChannelID, sch.Idle)
+	}
+	output := fmt.Sprintf("#%d in <#%s>: `%s` %s", sch.ID, sch.ChannelID, sch.Cron, sch.location)
+	if next := sch.Next(); !next.IsZero() {
+		output += fmt.Sprintf(", next <t:%d:R>", next.Unix())
+	}
+	return output
+}
+
+// scheduleJSON is a Schedule with the idle time written so people can read it
+type scheduleJSON struct {
+	ID        int       `json:"ID"`
+	ChannelID string    `json:"ChannelID"`
+	Cron      string    `json:"Cron,omitempty"`
+	Timezone  string    `json:"Timezone,omitempty"`
+	Idle      string    `json:"Idle,omitempty"`
+	Last      time.Time `json:"Last"`
+}
+
+func (sch *Schedule) MarshalJSON() ([]byte, error) {
+	output := scheduleJSON{ID: sch.ID, ChannelID: sch.ChannelID, Cron: sch.Cron, Timezone: sch.Timezone, Last: sch.Last}
+	if sch.Idle > 0 {
+		output.Idle = sch.Idle.String()
+	}
+	return json.Marshal(output)
+}
+
+func (sch *Schedule) UnmarshalJSON(data []byte) error {
+	var input scheduleJSON
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	*sch = Schedule{ID: input.ID, ChannelID: input.ChannelID, Cron: input.Cron, Timezone: input.Timezone, Last: input.Last}
+	if input.Idle != "" {
+		idle, err := time.ParseDuration(input.Idle)
+		if err != nil {
+			return err
+		}
+		sch.Idle = idle
+	}
+	return sch.parse()
+}
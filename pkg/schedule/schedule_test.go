@@ -0,0 +1,114 @@
+package schedule
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@sometimes"} {
+		if _, err := ParseCron(bad); err != ErrInvalidCron {
+			t.Error("Expected", bad, "to be invalid, got", err)
+		}
+	}
+
+	c, err := ParseCron("*/15 9-17 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-01-01 was a Monday
+	monday := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	if !c.Matches(monday) || c.Matches(monday.Add(time.Minute)) || c.Matches(monday.AddDate(0, 0, 5)) {
+		t.Fatal("Matches got weekdays or steps wrong")
+	}
+
+	// Sunday can be 7, and a day with a weekday means either
+	c, _ = ParseCron("0 0 13 * 7")
+	if !c.Matches(time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)) || !c.Matches(time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("Expected both Sundays and the 13th to match")
+	}
+}
+
+func TestNext(t *testing.T) {
+	start := time.Date(2024, 1, 31, 23, 59, 30, 0, time.UTC)
+	cases := map[string]time.Time{
+		"@hourly":     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		"30 9 * * *":  time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC),
+		"0 12 29 2 *": time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC),
+		"0 0 1 3 *":   time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":  {},
+	}
+	for expr, expected := range cases {
+		c, err := ParseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := c.Next(start); !next.Equal(expected) {
+			t.Error("Expected", expr, "to be next at", expected, "got", next)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	sch, err := NewCron("chan", "0 9 * * *", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sch.Last = now
+	if sch.Check(now.Add(30*time.Minute), now) {
+		t.Fatal("Posted before it was due")
+	}
+	if !sch.Check(now.Add(time.Hour+time.Minute), now) {
+		t.Fatal("Expected a post just after 9")
+	}
+	if sch.Check(now.Add(time.Hour+2*time.Minute), now) {
+		t.Fatal("Expected one post per match")
+	}
+	// Down all of the next morning, the late post is skipped but it carries on after
+	if sch.Check(now.Add(26*time.Hour), now) || !sch.Last.Equal(now.Add(26*time.Hour)) {
+		t.Fatal("Expected a missed post to be skipped")
+	}
+
+	idle, err := NewIdle("chan", 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewIdle("chan", time.Minute); err != ErrIdleTooShort {
+		t.Fatal("Expected too short, got", err)
+	}
+	idle.Last = now
+	if idle.Check(now.Add(4*time.Hour), now.Add(2*time.Hour)) {
+		t.Fatal("Posted while the channel was active")
+	}
+	if !idle.Check(now.Add(5*time.Hour), now.Add(2*time.Hour)) {
+		t.Fatal("Expected a post after 3 quiet hours")
+	}
+	if idle.Check(now.Add(6*time.Hour), now.Add(2*time.Hour)) {
+		t.Fatal("Expected to wait another 3 hours before posting again")
+	}
+}
+
+func TestScheduleJSON(t *testing.T) {
+	sch, err := NewCron("chan", "@daily", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, _ := NewIdle("chan", 90*time.Minute)
+	idle.ID = 2
+	data, err := json.Marshal([]*Schedule{sch, idle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := []*Schedule{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].Next().IsZero() || loaded[1].Idle != 90*time.Minute || loaded[1].ID != 2 {
+		t.Fatal("Schedules didn't survive JSON:", string(data))
+	}
+	if err := json.Unmarshal([]byte(`{"Cron":"nonsense"}`), &Schedule{}); err != ErrInvalidCron {
+		t.Fatal("Expected a bad expression to fail loading, got", err)
+	}
+}
@@ -0,0 +1,69 @@
+package servsync
+
+import (
+	"time"
+)
+
+// Recent activity per channel, used to aim for a number of bot messages an hour instead of fixed odds
+// Like topics none of this is saved, after a restart it takes a window to settle back in
+
+var ActivityWindow = time.Hour // How far back activity is counted
+
+type activity struct {
+	messages []time.Time // When people said something
+	posts    []time.Time // When the bot said something
+}
+
+// trim drops anything that's fallen out of the window
+func trim(times []time.Time, cutoff time.Time) []time.Time {
+	idx := 0
+	for idx < len(times) && times[idx].Before(cutoff) {
+		idx++
+	}
+	return times[idx:]
+}
+
+// channelActivity gets a channel's activity with the old stuff dropped, the caller holds the lock
+func (u *ServSync) channelActivity(channelId string) *activity {
+	if u.activity == nil {
+		u.activity = map[string]*activity{}
+	}
+	act, ok := u.activity[channelId]
+	if !ok {
+		act = &activity{}
+		u.activity[channelId] = act
+	}
+	cutoff := timeNow().Add(-ActivityWindow)
+	act.messages = trim(act.messages, cutoff)
+	act.posts = trim(act.posts, cutoff)
+	return act
+}
+
+// NoteMessage records someone saying something in a channel
+func (u *ServSync) NoteMessage(channelId string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	act := u.channelActivity(channelId)
+	act.messages = append(act.messages, timeNow())
+}
+
+// NotePost records the bot saying something in a channel
+func (u *ServSync) NotePost(channelId string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	act := u.channelActivity(channelId)
+	act.posts = append(act.posts, timeNow())
+}
+
+// AdaptiveOdds works out the chance from 0 to 1 of replying to a message so the bot posts about target times an hour
+// It assumes the next window will be as busy as the last one, and whatever's left of the target gets spread over it
+func (u *ServSync) AdaptiveOdds(channelId string, target uint) float64 {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	act := u.channelActivity(channelId)
+	posted := uint(len(act.posts))
+	if posted >= target {
+		return 0
+	}
+	return min(1, float64(target-posted)/float64(max(len(act.messages), 1)))
+}
@@ -0,0 +1,126 @@
+package servsync
+
+import (
+	"slices"
+)
+
+// Which channels a server's chain learns from and talks in
+// ChanId is still the server's main channel, it's where bark posts from other channels go
+
+type Channel struct {
+	Learn bool  `json:"Learn"`          // Messages here are learned from
+	Reply bool  `json:"Reply"`          // The bot can talk here
+	Odds  *uint `json:"Odds,omitempty"` // Odds out of 100 of replying here, nil uses the server's setting
+}
+
+// SetMainChannel makes a channel the main one, learning from and replying in it
+func (u *ServSync) SetMainChannel(channelId string) {
+	u.mutex.Lock()
+	u.ChanId = channelId
+	u.mutex.Unlock()
+	u.SetChannel(channelId, true, true)
+}
+
+// SetChannel sets whether a channel is learned from and/or replied in, turning both off removes it
+func (u *ServSync) SetChannel(channelId string, learn bool, reply bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if !learn && !reply {
+		delete(u.channels, channelId)
+		return
+	}
+	if u.channels == nil {
+		u.channels = map[string]*Channel{}
+	}
+	if ch, ok := u.channels[channelId]; ok {
+		ch.Learn = learn
+		ch.Reply = reply
+		return
+	}
+	u.channels[channelId] = &Channel{Learn: learn, Reply: reply}
+}
+
+// SetChannelOdds changes the reply odds for one channel, nil goes back to the server's setting
+func (u *ServSync) SetChannelOdds(channelId string, odds *uint) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	ch, ok := u.channels[channelId]
+	if !ok {
+		return false
+	}
+	ch.Odds = odds
+	return true
+}
+
+// Channels gets a copy of every configured channel
+func (u *ServSync) Channels() map[string]Channel {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	output := make(map[string]Channel, len(u.channels))
+	for id, ch := range u.channels {
+		output[id] = *ch
+	}
+	return output
+}
+
+// Ignore stops a channel from ever being learned from, returns false if it already was
+func (u *ServSync) Ignore(channelId string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if slices.Contains(u.ignored, channelId) {
+		return false
+	}
+	u.ignored = append(u.ignored, channelId)
+	return true
+}
+
+// Unignore takes a channel off the ignore list, returns false if it wasn't on it
+func (u *ServSync) Unignore(channelId string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	before := len(u.ignored)
+	u.ignored = slices.DeleteFunc(u.ignored, func(v string) bool { return v == channelId })
+	return len(u.ignored) != before
+}
+
+// Ignored lists the channels that are never learned from
+func (u *ServSync) Ignored() []string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return slices.Clone(u.ignored)
+}
+
+// IsIgnored checks if a channel is on the ignore list
+func (u *ServSync) IsIgnored(channelId string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return slices.Contains(u.ignored, channelId)
+}
+
+// CanLearn checks if messages in a channel should be learned from
+func (u *ServSync) CanLearn(channelId string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	ch, ok := u.channels[channelId]
+	return ok && ch.Learn && !slices.Contains(u.ignored, channelId)
+}
+
+// CanReply checks if the bot is allowed to talk in a channel
+func (u *ServSync) CanReply(channelId string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	ch, ok := u.channels[channelId]
+	return ok && ch.Reply
+}
+
+// Odds gets the odds out of 100 of replying to a message in a channel
+func (u *ServSync) Odds(channelId string) uint {
+	u.mutex.RLock()
+	if ch, ok := u.channels[channelId]; ok && ch.Odds != nil {
+		odds := *ch.Odds
+		u.mutex.RUnlock()
+		return odds
+	}
+	u.mutex.RUnlock()
+	return u.Settings().PostingOdds
+}
@@ -0,0 +1,183 @@
+package servsync
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/schedule"
+)
+
+// Exporting a server's chain and settings to a zip, and importing them back, possibly on another instance of the bot
+// The zip has server.json, which is the same as the server's entry in the config, and chain.json, which is the chain's save file
+
+const (
+	archiveConfig = "server.json"
+	archiveChain  = "chain.json"
+)
+
+var MaxImportSize int64 = 256 << 20 // Biggest file in an archive that will be imported, unzipped
+
+var (
+	ErrBadArchive    = errors.New("that isn't an exported server, it needs " + archiveConfig + " and " + archiveChain)
+	ErrArchiveTooBig = errors.New("that archive is too big to import")
+	ErrCantExport    = errors.New("this server's chain can't be exported")
+	ErrCantMerge     = errors.New("this server's chain can't be merged into")
+	ErrCantReplace   = errors.New("this server's chain can't be replaced")
+)
+
+// encoder is implemented by chains that can serialize themselves without going through a file
+type encoder interface {
+	Encode() ([]byte, error)
+}
+
+// merger is implemented by chains that can take in another chain
+type merger interface {
+	Merge(other *markovcommon.MarkovData, weight float64) error
+}
+
+// replacer is implemented by chains that can swap their contents for another chain's in place
+// The chain is read without the server's lock everywhere, so the pointer itself can't change
+type replacer interface {
+	Replace(other *markovcommon.MarkovData)
+}
+
+// Export writes the server's chain and settings to a zip
+func (u *ServSync) Export(w io.Writer) error {
+	enc, ok := u.MarkovChain.(encoder)
+	if !ok {
+		return ErrCantExport
+	}
+	chain, err := enc.Encode()
+	if err != nil {
+		return err
+	}
+	u.mutex.RLock()
+	aux := u.config()
+	aux.FileName, aux.LeftAt = "", nil
+	config, err := json.MarshalIndent(aux, "", "\t")
+	u.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{archiveConfig, config}, {archiveChain, chain}} {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readArchiveFile reads one file out of the zip, stopping if it's bigger than MaxImportSize
+func readArchiveFile(zr *zip.Reader, name string) ([]byte, error) {
+	file, err := zr.Open(name)
+	if err != nil {
+		return nil, ErrBadArchive
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, MaxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxImportSize {
+		return nil, ErrArchiveTooBig
+	}
+	return data, nil
+}
+
+// Import loads an archive from Export
+// Replacing swaps in the archive's chain and settings, merging adds the archive's chain to this one and keeps the settings
+// Channels and schedules only come across if inGuild says the channel is in this server
+// Admin roles, opt-ins and the main channel are never taken from an archive, anyone could have edited it
+// Settings out of the range the commands allow make the archive bad rather than being clamped
+// Nothing changes unless the whole archive checks out
+func (u *ServSync) Import(r io.ReaderAt, size int64, merge bool, inGuild func(channelId string) bool) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return ErrBadArchive
+	}
+	configData, err := readArchiveFile(zr, archiveConfig)
+	if err != nil {
+		return err
+	}
+	chainData, err := readArchiveFile(zr, archiveChain)
+	if err != nil {
+		return err
+	}
+	chain, err := markovcommon.Decode(chainData)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBadArchive, err.Error())
+	}
+	aux := &servSyncJSON{}
+	if err := json.Unmarshal(configData, aux); err != nil {
+		return fmt.Errorf("%w: %s", ErrBadArchive, err.Error())
+	}
+	if aux.Settings != nil {
+		if err := aux.Settings.validate(); err != nil {
+			return fmt.Errorf("%w: %s", ErrBadArchive, err.Error())
+		}
+	}
+	for _, ch := range aux.Channels {
+		if ch == nil || (ch.Odds != nil && *ch.Odds > MaxPostingOdds) {
+			return fmt.Errorf("%w: %s", ErrBadArchive, ErrInvalidOdds.Error())
+		}
+	}
+
+	if merge {
+		m, ok := u.MarkovChain.(merger)
+		if !ok {
+			return ErrCantMerge
+		}
+		return m.Merge(chain, 1)
+	}
+	rep, ok := u.MarkovChain.(replacer)
+	if !ok {
+		return ErrCantReplace
+	}
+	channels := map[string]*Channel{}
+	for id, ch := range aux.Channels {
+		if inGuild(id) {
+			channels[id] = ch
+		}
+	}
+	ignored := []string{}
+	for _, id := range aux.Ignored {
+		if inGuild(id) {
+			ignored = append(ignored, id)
+		}
+	}
+	schedules := []*schedule.Schedule{}
+	for _, sch := range aux.Schedules {
+		if inGuild(sch.ChannelID) {
+			schedules = append(schedules, sch)
+		}
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides = overrides{}
+	if aux.Settings != nil {
+		u.overrides = *aux.Settings
+	}
+	// The main channel always stays set up, otherwise an archive from somewhere else would leave nothing
+	if _, ok := channels[u.ChanId]; !ok && u.ChanId != "" {
+		channels[u.ChanId] = &Channel{Learn: true, Reply: true}
+	}
+	u.channels = channels
+	u.ignored = ignored
+	u.schedules = schedules
+	rep.Replace(chain)
+	return nil
+}
@@ -0,0 +1,59 @@
+package servsync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Keeping track of servers the bot has been removed from, so their data can be cleaned up after a while
+
+// MarkLeft records when the bot was removed from the server
+func (u *ServSync) MarkLeft(at time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.leftAt = at
+}
+
+// MarkActive clears MarkLeft, for when the bot is added back
+func (u *ServSync) MarkActive() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.leftAt = time.Time{}
+}
+
+// LeftAt gets when the bot was removed from the server, false if it's still there
+func (u *ServSync) LeftAt() (time.Time, bool) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.leftAt, !u.leftAt.IsZero()
+}
+
+// Archive moves the server's chain and config into a folder of their own under dir, and returns the folder
+// The server shouldn't be used afterwards, its chain file has moved
+func (u *ServSync) Archive(dir string, guildId string) (string, error) {
+	if err := u.Save(); err != nil {
+		return "", err
+	}
+	config, err := json.MarshalIndent(u, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	folder := filepath.Join(dir, guildId+"_"+time.Now().Format("20060102150405"))
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(folder, "server.json"), config, 0644); err != nil {
+		return "", err
+	}
+	return folder, os.Rename(u.FileName, filepath.Join(folder, filepath.Base(u.FileName)))
+}
+
+// Delete removes the server's chain file
+func (u *ServSync) Delete() error {
+	if err := os.Remove(u.FileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package servsync
+
+import (
+	"slices"
+	"time"
+
+	"github.com/danielh2942/markov_thingy/pkg/schedule"
+)
+
+// Schedules for posting without being prompted, these get saved with the rest of the server's config
+
+// AddSchedule adds a schedule and gives it the next free ID
+func (u *ServSync) AddSchedule(sch *schedule.Schedule) int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	sch.ID = 1
+	for _, existing := range u.schedules {
+		sch.ID = max(sch.ID, existing.ID+1)
+	}
+	u.schedules = append(u.schedules, sch)
+	return sch.ID
+}
+
+// RemoveSchedule removes a schedule by ID, returning false if there wasn't one
+func (u *ServSync) RemoveSchedule(id int) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	count := len(u.schedules)
+	u.schedules = slices.DeleteFunc(u.schedules, func(sch *schedule.Schedule) bool { return sch.ID == id })
+	return len(u.schedules) != count
+}
+
+// Schedules lists copies of the server's schedules
+func (u *ServSync) Schedules() []schedule.Schedule {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	output := make([]schedule.Schedule, 0, len(u.schedules))
+	for _, sch := range u.schedules {
+		output = append(output, *sch)
+	}
+	return output
+}
+
+// DueSchedules finds the schedules that should post now and marks them as having posted
+// lastActivity gets when something was last said in a channel, for idle schedules
+// It can be slow, so it's called before taking the lock that everything else reading the server needs
+func (u *ServSync) DueSchedules(now time.Time, lastActivity func(channelId string) time.Time) []schedule.Schedule {
+	idle := map[string]time.Time{}
+	u.mutex.RLock()
+	for _, sch := range u.schedules {
+		if sch.Cron == "" {
+			idle[sch.ChannelID] = time.Time{}
+		}
+	}
+	u.mutex.RUnlock()
+	for channelId := range idle {
+		idle[channelId] = lastActivity(channelId)
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	output := []schedule.Schedule{}
+	for _, sch := range u.schedules {
+		var last time.Time
+		if sch.Cron == "" {
+			last = idle[sch.ChannelID]
+		}
+		if sch.Check(now, last) {
+			output = append(output, *sch)
+		}
+	}
+	return output
+}
@@ -3,32 +3,194 @@ package servsync
 import (
 	"encoding/json"
 	"errors"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/schedule"
 	"github.com/google/uuid"
 )
 
 // Preliminary setup to allow for multi server support
 
 type ServSync struct {
-	ChanId      string                   // Channel that messages are read from
+	ChanId      string                   // Main channel, see channels.go for the rest
 	FileName    string                   // name of file database is written to
 	MsgCount    atomic.Uint64            // count of messages sent
 	MarkovChain markovcommon.MarkovChain // markov chain stored/used
+	optedIn     []string                 // users that agreed to have their messages attributed to them
+	adminRoles  []string                 // roles allowed to run admin commands without Manage Server
+	overrides   overrides                // settings this server changed from the defaults
+	channels    map[string]*Channel      // channels learned from and talked in
+	ignored     []string                 // channels that are never learned from
+	topics      map[string][]topic       // recent topics per channel, not saved
+	activity    map[string]*activity     // recent messages and posts per channel, not saved
+	schedules   []*schedule.Schedule     // when to post without being prompted
+	leftAt      time.Time                // when the bot was removed from the server, zero while it's still there
+	mutex       sync.RWMutex             // protects everything that isn't atomic or the chain
+	saveMutex   sync.Mutex               // one save of the chain file at a time
+}
+
+// servSyncJSON is what gets written to the config file
+type servSyncJSON struct {
+	ChanId     string               `json:"ChanId"`
+	FileName   string               `json:"FileName"`
+	OptedIn    []string             `json:"OptedIn,omitempty"`
+	AdminRoles []string             `json:"AdminRoles,omitempty"`
+	Settings   *overrides           `json:"Settings,omitempty"`
+	Channels   map[string]*Channel  `json:"Channels,omitempty"`
+	Ignored    []string             `json:"Ignored,omitempty"`
+	Schedules  []*schedule.Schedule `json:"Schedules,omitempty"`
+	LeftAt     *time.Time           `json:"LeftAt,omitempty"`
 }
 
 func (u *ServSync) Save() error {
+	u.saveMutex.Lock()
+	defer u.saveMutex.Unlock()
 	return u.MarkovChain.SaveToFile(u.FileName)
 }
 
+// Prune compacts the markov chain, dropping edges seen less than minCount times
+func (u *ServSync) Prune(minCount uint) (markovcommon.PruneStats, error) {
+	pruner, ok := u.MarkovChain.(markovcommon.Pruner)
+	if !ok {
+		return markovcommon.PruneStats{}, errors.New("markov chain does not support pruning")
+	}
+	return pruner.Prune(minCount), nil
+}
+
+// OptIn lets a user's messages be tracked against them
+func (u *ServSync) OptIn(userId string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if !slices.Contains(u.optedIn, userId) {
+		u.optedIn = append(u.optedIn, userId)
+	}
+}
+
+// OptOut stops tracking a user and forgets what was tracked against them
+func (u *ServSync) OptOut(userId string) {
+	u.mutex.Lock()
+	u.optedIn = slices.DeleteFunc(u.optedIn, func(v string) bool { return v == userId })
+	u.mutex.Unlock()
+	if tracker, ok := u.MarkovChain.(markovcommon.AuthorTracker); ok {
+		tracker.ForgetAuthor(userId)
+	}
+}
+
+// IsOptedIn checks if a user agreed to have their messages tracked
+func (u *ServSync) IsOptedIn(userId string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return slices.Contains(u.optedIn, userId)
+}
+
+// Learn adds a message to the chain, attributing it to the author if they opted in
+// The message ID is remembered (hashed) so it can be taken back out with Unlearn or Forget
+func (u *ServSync) Learn(messageId string, authorId string, content string) error {
+	optedIn := u.IsOptedIn(authorId)
+	if tracker, ok := u.MarkovChain.(markovcommon.ProvenanceTracker); ok && messageId != "" {
+		return tracker.AddMessage(messageId, authorId, content, optedIn)
+	}
+	if tracker, ok := u.MarkovChain.(markovcommon.AuthorTracker); ok && optedIn {
+		return tracker.AddStringFromAuthor(authorId, content)
+	}
+	return u.MarkovChain.AddStringToData(content)
+}
+
+// HasLearned checks if a message is already in the chain, always false if the chain doesn't track messages
+func (u *ServSync) HasLearned(messageId string) bool {
+	tracker, ok := u.MarkovChain.(markovcommon.ProvenanceTracker)
+	return ok && messageId != "" && tracker.HasMessage(messageId)
+}
+
+// Unlearn takes a message back out of the chain, the opposite of Learn
+// Messages learned before their IDs were tracked are removed by content instead, if it's known
+// It returns false if there was nothing to go on
+func (u *ServSync) Unlearn(messageId string, authorId string, content string) (bool, error) {
+	if tracker, ok := u.MarkovChain.(markovcommon.ProvenanceTracker); ok && messageId != "" {
+		_, err := tracker.RemoveMessage(messageId)
+		if !errors.Is(err, markovcommon.ErrUnknownMessage) {
+			return err == nil, err
+		}
+	}
+	if content == "" {
+		return false, nil
+	}
+	unlearner, ok := u.MarkovChain.(markovcommon.Unlearner)
+	if !ok {
+		return false, errors.New("markov chain does not support unlearning")
+	}
+	if u.IsOptedIn(authorId) {
+		return true, unlearner.RemoveStringFromAuthor(authorId, content)
+	}
+	return true, unlearner.RemoveStringFromData(content)
+}
+
+// Forget takes everything a user taught the chain back out, as far as it was tracked
+func (u *ServSync) Forget(userId string) (markovcommon.ForgetStats, error) {
+	tracker, ok := u.MarkovChain.(markovcommon.ProvenanceTracker)
+	if !ok {
+		return markovcommon.ForgetStats{}, errors.New("markov chain does not support forgetting users")
+	}
+	return tracker.ForgetUser(userId), nil
+}
+
+// Impersonate generates a sentence mostly out of what one user has said
+func (u *ServSync) Impersonate(userId string, limit int, mix float64) (string, error) {
+	if !u.IsOptedIn(userId) {
+		return "", errors.New("user has not opted in")
+	}
+	tracker, ok := u.MarkovChain.(markovcommon.AuthorTracker)
+	if !ok {
+		return "", errors.New("markov chain does not support tracking authors")
+	}
+	return tracker.GenerateSentenceAs(userId, limit, mix)
+}
+
+// AllowRole lets a role run admin commands, returns false if it already could
+func (u *ServSync) AllowRole(roleId string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if slices.Contains(u.adminRoles, roleId) {
+		return false
+	}
+	u.adminRoles = append(u.adminRoles, roleId)
+	return true
+}
+
+// DisallowRole takes a role off the admin list, returns false if it wasn't on it
+func (u *ServSync) DisallowRole(roleId string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	before := len(u.adminRoles)
+	u.adminRoles = slices.DeleteFunc(u.adminRoles, func(v string) bool { return v == roleId })
+	return len(u.adminRoles) != before
+}
+
+// AdminRoles lists the roles allowed to run admin commands
+func (u *ServSync) AdminRoles() []string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return slices.Clone(u.adminRoles)
+}
+
+// HasAdminRole checks if any of the given roles are allowed to run admin commands
+func (u *ServSync) HasAdminRole(roles []string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return slices.ContainsFunc(roles, func(v string) bool { return slices.Contains(u.adminRoles, v) })
+}
+
 func New(ChanId string) *ServSync {
 	mUUID := uuid.New()
 	return &ServSync{
-		ChanId,
-		mUUID.String() + ".json",
-		atomic.Uint64{},
-		&markovcommon.MarkovData{
+		ChanId:   ChanId,
+		FileName: mUUID.String() + ".json",
+		channels: map[string]*Channel{ChanId: {Learn: true, Reply: true}},
+		MarkovChain: &markovcommon.MarkovData{
 			StartWords: []uint{},
 			WordCount:  0,
 			WordRef:    map[string]uint{},
@@ -38,31 +200,42 @@ func New(ChanId string) *ServSync {
 	}
 }
 
-func (u ServSync) MarshalJSON() ([]byte, error) {
-	if err := u.Save(); err != nil {
-		return []byte{}, errors.New("Failed to save file.")
+// MarshalJSON writes the server's entry in the config, the chain is saved separately with Save
+func (u *ServSync) MarshalJSON() ([]byte, error) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return json.Marshal(u.config())
+}
+
+// config is everything about the server that goes in the config file, the caller holds the lock
+func (u *ServSync) config() *servSyncJSON {
+	aux := &servSyncJSON{
+		ChanId:     u.ChanId,
+		FileName:   u.FileName,
+		OptedIn:    u.optedIn,
+		AdminRoles: u.adminRoles,
+		Channels:   u.channels,
+		Ignored:    u.ignored,
+		Schedules:  u.schedules,
 	}
-	return json.Marshal(&struct {
-		ChanId   string `json:"ChanId"`
-		FileName string `json:"FileName"`
-	}{
-		ChanId:   u.ChanId,
-		FileName: u.FileName,
-	})
+	if !u.overrides.isEmpty() {
+		aux.Settings = &u.overrides
+	}
+	if !u.leftAt.IsZero() {
+		aux.LeftAt = &u.leftAt
+	}
+	return aux
 }
 
 func (u *ServSync) UnmarshalJSON(data []byte) error {
-	aux := &struct {
-		ChanId   string `json:"ChanId"`
-		FileName string `json:"FileName"`
-	}{}
+	aux := &servSyncJSON{}
 
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
 	}
 
-	u.ChanId = aux.ChanId
 	u.FileName = aux.FileName
+	u.applyConfig(aux)
 	u.MsgCount.Store(0)
 	if tmp, err := markovcommon.ReadinFile(u.FileName); err != nil {
 		return err
@@ -71,3 +244,25 @@ func (u *ServSync) UnmarshalJSON(data []byte) error {
 	}
 	return nil
 }
+
+// applyConfig sets up everything but the file name and chain from the config, the caller holds the lock if it needs one
+func (u *ServSync) applyConfig(aux *servSyncJSON) {
+	u.ChanId = aux.ChanId
+	u.optedIn = aux.OptedIn
+	u.adminRoles = aux.AdminRoles
+	u.overrides = overrides{}
+	if aux.Settings != nil {
+		u.overrides = *aux.Settings
+	}
+	u.channels = aux.Channels
+	u.ignored = aux.Ignored
+	u.schedules = aux.Schedules
+	u.leftAt = time.Time{}
+	if aux.LeftAt != nil {
+		u.leftAt = *aux.LeftAt
+	}
+	// Configs from before there were multiple channels only have the main one
+	if u.channels == nil && u.ChanId != "" {
+		u.channels = map[string]*Channel{u.ChanId: {Learn: true, Reply: true}}
+	}
+}
@@ -1,6 +1,19 @@
 package servsync
 
-import ("testing")
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielh2942/markov_thingy/pkg/markovcommon"
+	"github.com/danielh2942/markov_thingy/pkg/schedule"
+)
 
 func TestServSyncGet(t *testing.T) {
 	data := New("1234")
@@ -47,3 +60,423 @@ func TestDeleteFromSyncMap(t *testing.T) {
 		t.Fatal("Failed to delete from map")
 	}
 }
+
+func TestOptIn(t *testing.T) {
+	data := New("1234")
+
+	data.Learn("m1", "5678", "not tracked")
+	if _, err := data.Impersonate("5678", 10, 0); err == nil {
+		t.Fatal("Impersonated a user that didn't opt in")
+	}
+
+	data.OptIn("5678")
+	data.Learn("m2", "5678", "tracked now")
+	if msg, err := data.Impersonate("5678", 10, 0); err != nil || msg != "tracked now ." {
+		t.Fatalf("Expected \"tracked now .\", got %q (%v)", msg, err)
+	}
+
+	data.Learn("m3", "5678", "tracked again")
+	if ok, err := data.Unlearn("m3", "5678", ""); !ok || err != nil {
+		t.Fatal("Failed to unlearn by message ID", err)
+	}
+	if msg, err := data.Impersonate("5678", 10, 0); err != nil || msg != "tracked now ." {
+		t.Fatalf("Expected \"tracked now .\" after unlearning, got %q (%v)", msg, err)
+	}
+
+	data.OptOut("5678")
+	if data.IsOptedIn("5678") {
+		t.Fatal("User still opted in")
+	}
+}
+
+func TestAdminRoles(t *testing.T) {
+	data := New("1234")
+	if data.HasAdminRole([]string{"42"}) {
+		t.Fatal("Role allowed before being added")
+	}
+	if !data.AllowRole("42") || data.AllowRole("42") {
+		t.Fatal("Adding a role twice should only work once")
+	}
+	if !data.HasAdminRole([]string{"1", "42"}) {
+		t.Fatal("Allowed role not recognised")
+	}
+	if !data.DisallowRole("42") || data.DisallowRole("42") {
+		t.Fatal("Removing a role twice should only work once")
+	}
+	if len(data.AdminRoles()) != 0 {
+		t.Fatal("Role list not empty")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	oldDefaults := Defaults
+	defer func() { Defaults = oldDefaults }()
+	Defaults = Settings{PostingOdds: 20, BackupFreq: 100, MaxLength: 50, Features: map[string]bool{FeatureYoutube: false}}
+
+	data := New("1234")
+	if data.Settings().PostingOdds != 20 || data.Settings().Enabled(FeatureYoutube) || !data.Settings().Enabled(FeatureReplies) {
+		t.Fatal("Defaults not used")
+	}
+
+	data.SetPostingOdds(0)
+	data.SetMaxLength(10)
+	if err := data.SetFeature(FeatureYoutube, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.SetFeature("nonsense", true); err != ErrUnknownFeature {
+		t.Fatal("Expected unknown feature, got", err)
+	}
+	settings := data.Settings()
+	if settings.PostingOdds != 0 || settings.MaxLength != 10 || settings.BackupFreq != 100 || !settings.Enabled(FeatureYoutube) {
+		t.Fatal("Overrides not used:", settings)
+	}
+	if Defaults.Features[FeatureYoutube] {
+		t.Fatal("Overriding a feature changed the defaults")
+	}
+
+	// Changing the defaults only affects what wasn't overridden
+	Defaults.BackupFreq = 5
+	Defaults.PostingOdds = 50
+	if settings := data.Settings(); settings.BackupFreq != 5 || settings.PostingOdds != 0 {
+		t.Fatal("Expected new default backup and overridden odds, got", settings)
+	}
+
+	data.ResetSettings()
+	if data.Settings().PostingOdds != 50 || data.Settings().Enabled(FeatureYoutube) {
+		t.Fatal("Reset didn't go back to the defaults")
+	}
+}
+
+func TestChannels(t *testing.T) {
+	oldDefaults := Defaults
+	defer func() { Defaults = oldDefaults }()
+	Defaults.PostingOdds = 20
+
+	data := New("main")
+	if !data.CanLearn("main") || !data.CanReply("main") {
+		t.Fatal("Main channel not set up")
+	}
+
+	data.SetChannel("general", true, false)
+	data.SetChannel("bots", false, true)
+	if !data.CanLearn("general") || data.CanReply("general") {
+		t.Fatal("General should only be learned from")
+	}
+	if data.CanLearn("bots") || !data.CanReply("bots") {
+		t.Fatal("Bots should only be replied in")
+	}
+	if data.CanLearn("elsewhere") || data.CanReply("elsewhere") {
+		t.Fatal("Unconfigured channel used")
+	}
+
+	odds := uint(75)
+	if !data.SetChannelOdds("bots", &odds) || data.SetChannelOdds("elsewhere", &odds) {
+		t.Fatal("Odds should only be set on configured channels")
+	}
+	if data.Odds("bots") != 75 || data.Odds("main") != 20 {
+		t.Fatal("Expected 75 and 20, got", data.Odds("bots"), data.Odds("main"))
+	}
+
+	data.Ignore("general")
+	if data.CanLearn("general") || !data.IsIgnored("general") {
+		t.Fatal("Learning from an ignored channel")
+	}
+	data.Unignore("general")
+	if !data.CanLearn("general") {
+		t.Fatal("Unignored channel not learned from")
+	}
+
+	data.SetChannel("general", false, false)
+	if _, ok := data.Channels()["general"]; ok {
+		t.Fatal("Channel not removed")
+	}
+}
+
+func TestForget(t *testing.T) {
+	data := New("1234")
+	data.Learn("m1", "5678", "forget me please")
+	data.Learn("m2", "5678", "and this")
+	data.Learn("m3", "9999", "keep me")
+
+	stats, err := data.Forget("5678")
+	if err != nil || stats.Messages != 2 {
+		t.Fatal("Expected 2 messages forgotten, got", stats, err)
+	}
+	for i := 0; i < 10; i++ {
+		if msg, _ := data.MarkovChain.GenerateSentence(10); !strings.HasPrefix(msg, "keep me .") {
+			t.Fatal("Expected only what's left, got", msg)
+		}
+	}
+
+	// Messages without a tracked ID fall back to the content
+	data.MarkovChain.AddStringToData("old message")
+	if ok, err := data.Unlearn("m4", "9999", "old message"); !ok || err != nil {
+		t.Fatal("Failed to unlearn by content", err)
+	}
+	if ok, _ := data.Unlearn("m5", "9999", ""); ok {
+		t.Fatal("Unlearned something with nothing to go on")
+	}
+}
+
+func TestTopics(t *testing.T) {
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	data := New("1234")
+	data.RememberTopics("1234", []string{"cats", "dogs"})
+	data.RememberTopics("1234", []string{"Cats", "birds"})
+	if got := data.Topics("1234"); !slices.Equal(got, []string{"birds", "Cats", "dogs"}) {
+		t.Fatal("Expected birds, Cats, dogs got", got)
+	}
+	if len(data.Topics("5678")) != 0 {
+		t.Fatal("Topics leaked into another channel")
+	}
+
+	words := []string{}
+	for i := 0; i < TopicMemory+5; i++ {
+		words = append(words, "word"+strings.Repeat("x", i))
+	}
+	data.RememberTopics("1234", words)
+	if len(data.Topics("1234")) != TopicMemory {
+		t.Fatal("Expected topics to be capped at", TopicMemory)
+	}
+
+	now = now.Add(TopicExpiry + time.Minute)
+	if len(data.Topics("1234")) != 0 {
+		t.Fatal("Expected old topics to be forgotten")
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	data := New("1234")
+	if err := data.SetQuietHours(QuietHours{From: 25, To: 7}); err != ErrInvalidHour {
+		t.Fatal("Expected invalid hour, got", err)
+	}
+	if err := data.SetQuietHours(QuietHours{From: 23, To: 7, Timezone: "Nowhere/Special"}); err != ErrUnknownTimezone {
+		t.Fatal("Expected unknown timezone, got", err)
+	}
+	if err := data.SetQuietHours(QuietHours{From: 23, To: 7}); err != nil {
+		t.Fatal(err)
+	}
+	quiet := data.Settings().QuietHours
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for hour, expected := range map[int]bool{22: false, 23: true, 0: true, 6: true, 7: false, 12: false} {
+		if quiet.Contains(day.Add(time.Duration(hour)*time.Hour)) != expected {
+			t.Error("Expected quiet at", hour, "to be", expected)
+		}
+	}
+	if (QuietHours{From: 3, To: 3}).Contains(day.Add(3 * time.Hour)) {
+		t.Error("Expected the same start and end to mean no quiet hours")
+	}
+}
+
+func TestAdaptiveOdds(t *testing.T) {
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	data := New("1234")
+	if odds := data.AdaptiveOdds("1234", 4); odds != 1 {
+		t.Fatal("Expected a quiet channel to always get a reply, got", odds)
+	}
+	for i := 0; i < 40; i++ {
+		data.NoteMessage("1234")
+	}
+	if odds := data.AdaptiveOdds("1234", 4); odds != 0.1 {
+		t.Fatal("Expected 4 posts over 40 messages, got", odds)
+	}
+	data.NotePost("1234")
+	data.NotePost("1234")
+	if odds := data.AdaptiveOdds("1234", 4); odds != 0.05 {
+		t.Fatal("Expected 2 posts left over 40 messages, got", odds)
+	}
+	data.NotePost("1234")
+	data.NotePost("1234")
+	if odds := data.AdaptiveOdds("1234", 4); odds != 0 {
+		t.Fatal("Expected no more posts after hitting the target, got", odds)
+	}
+
+	now = now.Add(ActivityWindow + time.Minute)
+	if odds := data.AdaptiveOdds("1234", 4); odds != 1 {
+		t.Fatal("Expected old activity to fall out of the window, got", odds)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	data := New("1234")
+	daily, err := schedule.NewCron("1234", "0 9 * * *", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := schedule.NewIdle("5678", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.AddSchedule(daily) != 1 || data.AddSchedule(idle) != 2 {
+		t.Fatal("Expected schedules to be numbered from 1")
+	}
+
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	daily.Last, idle.Last = now, now
+	lastActivity := func(channelId string) time.Time {
+		if channelId != "5678" {
+			t.Fatal("Asked about activity for a cron schedule's channel", channelId)
+		}
+		return now
+	}
+	due := data.DueSchedules(now.Add(61*time.Minute), lastActivity)
+	if len(due) != 2 {
+		t.Fatal("Expected both schedules to be due, got", due)
+	}
+	if len(data.DueSchedules(now.Add(62*time.Minute), lastActivity)) != 0 {
+		t.Fatal("Expected nothing due straight after posting")
+	}
+
+	if !data.RemoveSchedule(1) || data.RemoveSchedule(1) {
+		t.Fatal("Expected removing to work once")
+	}
+	if data.AddSchedule(daily) != 3 {
+		t.Fatal("Expected IDs not to be reused while higher ones exist")
+	}
+	if got := data.Schedules(); len(got) != 2 || got[0].ID != 2 {
+		t.Fatal("Unexpected schedules", got)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	oldDefaults := Defaults
+	defer func() { Defaults = oldDefaults }()
+	Defaults.GoodEmoji, Defaults.BadEmoji = []string{"👍"}, []string{"👎"}
+
+	data := New("1234")
+	settings := data.Settings()
+	if settings.Reaction("👍") != 1 || settings.Reaction("👎") != -1 || settings.Reaction("🐈") != 0 {
+		t.Fatal("Default reactions not used")
+	}
+	data.SetReactions(true, []string{"🐈", "pog:1234"})
+	data.SetReactions(false, []string{})
+	settings = data.Settings()
+	if settings.Reaction("👍") != 0 || settings.Reaction("pog:1234") != 1 || settings.Reaction("👎") != 0 {
+		t.Fatal("Reactions not overridden:", settings)
+	}
+	data.SetReactions(false, nil)
+	if data.Settings().Reaction("👎") != -1 {
+		t.Fatal("Expected bad reactions back on the defaults")
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	data := New("1234")
+	data.FileName = filepath.Join(dir, "chain.json")
+	data.MarkovChain.AddStringToData("hello there")
+	if _, left := data.LeftAt(); left {
+		t.Fatal("New servers shouldn't have left")
+	}
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data.MarkLeft(at)
+	// The config only points at the chain, it has to be saved on its own
+	if err := data.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &ServSync{}
+	if err := json.Unmarshal(saved, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if leftAt, left := loaded.LeftAt(); !left || !leftAt.Equal(at) {
+		t.Fatal("Leaving wasn't saved:", string(saved))
+	}
+	loaded.MarkActive()
+	if saved, _ := json.Marshal(loaded); strings.Contains(string(saved), "LeftAt") {
+		t.Fatal("Active servers shouldn't save LeftAt:", string(saved))
+	}
+
+	folder, err := data.Archive(filepath.Join(dir, "archive"), "guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(data.FileName); !os.IsNotExist(err) {
+		t.Fatal("Expected the chain to be moved out")
+	}
+	for _, name := range []string{"server.json", "chain.json"} {
+		if _, err := os.Stat(filepath.Join(folder, name)); err != nil {
+			t.Fatal("Expected", name, "in the archive:", err)
+		}
+	}
+	if err := data.Delete(); err != nil {
+		t.Fatal("Deleting a chain that's already gone should be fine, got", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	from := New("1234")
+	from.FileName = filepath.Join(dir, "from.json")
+	from.MarkovChain.AddStringToData("the cat sat")
+	from.SetMaxLength(12)
+	from.SetChannel("5678", true, false)
+	from.SetChannel("4321", true, true)
+	from.OptIn("alice")
+	from.AllowRole("role")
+	archive := &bytes.Buffer{}
+	if err := from.Export(archive); err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(archive.Bytes())
+
+	into := New("9999")
+	into.FileName = filepath.Join(dir, "into.json")
+	into.MarkovChain.AddStringToData("the dog sat")
+	// 5678 is in the server being imported into, 1234 and 4321 are somewhere else
+	inGuild := func(channelId string) bool { return channelId == "5678" || channelId == "9999" }
+	if err := into.Import(reader, reader.Size(), true, inGuild); err != nil {
+		t.Fatal(err)
+	}
+	chain := into.MarkovChain.(*markovcommon.MarkovData)
+	if _, ok := chain.WordRef["cat"]; !ok || into.Settings().MaxLength == 12 || into.CanLearn("5678") {
+		t.Fatal("Merging should add the chain and keep the settings")
+	}
+
+	if err := into.Import(reader, reader.Size(), false, inGuild); err != nil {
+		t.Fatal(err)
+	}
+	// Everything else holds onto the chain without the server's lock, so it gets replaced in place
+	if into.MarkovChain != chain {
+		t.Fatal("Replacing shouldn't swap out the chain itself")
+	}
+	if _, ok := chain.WordRef["dog"]; ok || into.Settings().MaxLength != 12 || !into.CanLearn("5678") {
+		t.Fatal("Replacing should swap in the chain and settings")
+	}
+	// Nothing that could point the bot at another server or hand out permissions comes across
+	if into.ChanId != "9999" || !into.CanReply("9999") || into.CanLearn("1234") || into.CanReply("4321") {
+		t.Fatal("Channels from another server shouldn't be imported", into.Channels())
+	}
+	if into.IsOptedIn("alice") || into.HasAdminRole([]string{"role"}) {
+		t.Fatal("Opt-ins and admin roles shouldn't be imported")
+	}
+	if into.FileName != filepath.Join(dir, "into.json") {
+		t.Fatal("Importing shouldn't move where the chain is saved")
+	}
+
+	// Settings the commands wouldn't allow make the whole archive bad
+	from.SetMaxLength(1000000000)
+	archive.Reset()
+	if err := from.Export(archive); err != nil {
+		t.Fatal(err)
+	}
+	reader = bytes.NewReader(archive.Bytes())
+	if err := into.Import(reader, reader.Size(), false, inGuild); !errors.Is(err, ErrBadArchive) || into.Settings().MaxLength != 12 {
+		t.Fatal("Expected an out of range length to be rejected, got", err, into.Settings().MaxLength)
+	}
+
+	garbage := bytes.NewReader([]byte("not a zip"))
+	if err := into.Import(garbage, garbage.Size(), false, inGuild); err != ErrBadArchive {
+		t.Fatal("Expected a bad archive, got", err)
+	}
+}
@@ -0,0 +1,305 @@
+package servsync
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Per server settings
+// Anything a server hasn't set itself comes from Defaults, so changing the defaults changes every server that hasn't
+
+// Features that can be turned on and off per server
+const (
+	FeatureReplies     = "replies"     // Randomly replying to messages
+	FeatureMentions    = "mentions"    // Replying when mentioned or replied to
+	FeatureImpersonate = "impersonate" // The impersonate command
+	FeatureYoutube     = "ytrandom"    // The ytrandom command
+	FeatureThreads     = "threads"     // Treating threads and forum posts like the channel they're in
+	FeatureJoinThreads = "jointhreads" // Joining new threads in configured channels
+	FeatureSchedules   = "schedules"   // Posting on a schedule or when a channel goes quiet
+	FeatureTyping      = "typing"      // Showing the typing indicator and taking a human amount of time to reply
+	FeatureReactions   = "reactions"   // Reactions on the bot's messages nudging the chain
+)
+
+// Limits on the settings, the commands enforce these and so does importing
+const (
+	MaxPostingOdds   = 100 // Odds are out of 100
+	MaxSentence      = 500 // Most words a server can let a sentence run to
+	MaxTargetRate    = 60  // Most messages an hour per channel
+	MaxReactionEmoji = 20  // Most emoji in each of the good and bad lists
+)
+
+// Features lists every feature in the order they're shown
+var Features = []string{FeatureReplies, FeatureMentions, FeatureImpersonate, FeatureYoutube, FeatureThreads, FeatureJoinThreads, FeatureSchedules, FeatureTyping, FeatureReactions}
+
+var (
+	ErrUnknownFeature  = errors.New("unknown feature")
+	ErrInvalidHour     = errors.New("hours go from 0 to 23")
+	ErrUnknownTimezone = errors.New("unknown timezone, use one like Europe/Dublin")
+	ErrInvalidOdds     = errors.New("odds go from 0 to 100")
+	ErrInvalidBackup   = errors.New("saves have to be at least 1 message apart")
+	ErrInvalidLength   = errors.New("sentences can be 1 to 500 words")
+	ErrInvalidRate     = errors.New("the target rate goes from 0 to 60 messages an hour")
+	ErrTooManyEmoji    = errors.New("there can only be 20 good and 20 bad reactions")
+)
+
+// QuietHours is when the bot doesn't speak up on its own, From and To being the same means never
+type QuietHours struct {
+	From     int    // Hour quiet hours start
+	To       int    // Hour they end, this can be before From to go past midnight
+	Timezone string // Timezone the hours are in, UTC if empty
+}
+
+// location gets the timezone, falling back to UTC
+func (q QuietHours) location() *time.Location {
+	if loc, err := time.LoadLocation(q.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Contains checks if a time is inside quiet hours
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.From == q.To {
+		return false
+	}
+	hour := t.In(q.location()).Hour()
+	if q.From < q.To {
+		return hour >= q.From && hour < q.To
+	}
+	return hour >= q.From || hour < q.To
+}
+
+// validate checks the hours and timezone make sense
+func (q QuietHours) validate() error {
+	if q.From < 0 || q.From > 23 || q.To < 0 || q.To > 23 {
+		return ErrInvalidHour
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return ErrUnknownTimezone
+	}
+	return nil
+}
+
+func (q QuietHours) String() string {
+	if q.From == q.To {
+		return "none"
+	}
+	return fmt.Sprintf("%02d:00-%02d:00 %s", q.From, q.To, q.location())
+}
+
+type Settings struct {
+	PostingOdds uint            // Odds out of 100 of replying to a message
+	BackupFreq  uint64          // Save every n messages
+	MaxLength   int             // Most words in a generated sentence
+	TargetRate  uint            // Bot messages an hour to aim for per channel instead of fixed odds, 0 for fixed odds
+	QuietHours  QuietHours      // When the bot doesn't post on its own
+	GoodEmoji   []string        // Reactions that make the bot more likely to say something like that again
+	BadEmoji    []string        // Reactions that make it less likely
+	Features    map[string]bool // Features that are turned off are false, missing means on
+}
+
+// Defaults are used for anything a server hasn't set, the bot fills these in from its flags
+var Defaults = Settings{
+	PostingOdds: 20,
+	BackupFreq:  100,
+	MaxLength:   50,
+	GoodEmoji:   []string{"👍", "😂", "❤️"},
+	BadEmoji:    []string{"👎"},
+	Features:    map[string]bool{},
+}
+
+// Enabled checks if a feature is turned on
+func (s Settings) Enabled(feature string) bool {
+	on, ok := s.Features[feature]
+	return !ok || on
+}
+
+func (s Settings) String() string {
+	output := "Reply odds: " + strconv.FormatUint(uint64(s.PostingOdds), 10) + "/100\n"
+	output += "Save every: " + strconv.FormatUint(s.BackupFreq, 10) + " messages\n"
+	output += "Sentence length: " + strconv.Itoa(s.MaxLength) + " words\n"
+	if s.TargetRate == 0 {
+		output += "Target rate: off, using the reply odds\n"
+	} else {
+		output += "Target rate: " + strconv.FormatUint(uint64(s.TargetRate), 10) + " messages an hour per channel\n"
+	}
+	output += "Quiet hours: " + s.QuietHours.String() + "\n"
+	output += "Good reactions: " + strings.Join(s.GoodEmoji, " ") + "\n"
+	output += "Bad reactions: " + strings.Join(s.BadEmoji, " ") + "\n"
+	for _, feature := range Features {
+		state := "on"
+		if !s.Enabled(feature) {
+			state = "off"
+		}
+		output += "Feature " + feature + ": " + state + "\n"
+	}
+	return strings.TrimSuffix(output, "\n")
+}
+
+// overrides is what a server has set for itself, nil means use the default
+type overrides struct {
+	PostingOdds *uint           `json:"PostingOdds,omitempty"`
+	BackupFreq  *uint64         `json:"BackupFreq,omitempty"`
+	MaxLength   *int            `json:"MaxLength,omitempty"`
+	TargetRate  *uint           `json:"TargetRate,omitempty"`
+	QuietHours  *QuietHours     `json:"QuietHours,omitempty"`
+	GoodEmoji   *[]string       `json:"GoodEmoji,omitempty"`
+	BadEmoji    *[]string       `json:"BadEmoji,omitempty"`
+	Features    map[string]bool `json:"Features,omitempty"`
+}
+
+// validate checks everything is in the same range the commands allow, for settings from somewhere else
+func (o *overrides) validate() error {
+	switch {
+	case o.PostingOdds != nil && *o.PostingOdds > MaxPostingOdds:
+		return ErrInvalidOdds
+	case o.BackupFreq != nil && *o.BackupFreq == 0:
+		return ErrInvalidBackup
+	case o.MaxLength != nil && (*o.MaxLength < 1 || *o.MaxLength > MaxSentence):
+		return ErrInvalidLength
+	case o.TargetRate != nil && *o.TargetRate > MaxTargetRate:
+		return ErrInvalidRate
+	case o.GoodEmoji != nil && len(*o.GoodEmoji) > MaxReactionEmoji, o.BadEmoji != nil && len(*o.BadEmoji) > MaxReactionEmoji:
+		return ErrTooManyEmoji
+	}
+	for feature := range o.Features {
+		if !slices.Contains(Features, feature) {
+			return ErrUnknownFeature
+		}
+	}
+	if o.QuietHours != nil {
+		return o.QuietHours.validate()
+	}
+	return nil
+}
+
+// isEmpty checks if nothing has been overridden, so it can be left out of the config
+func (o *overrides) isEmpty() bool {
+	return o.PostingOdds == nil && o.BackupFreq == nil && o.MaxLength == nil && o.TargetRate == nil && o.QuietHours == nil && o.GoodEmoji == nil && o.BadEmoji == nil && len(o.Features) == 0
+}
+
+// Settings gets the settings a server is actually using
+func (u *ServSync) Settings() Settings {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	output := Defaults
+	output.Features = maps.Clone(Defaults.Features)
+	if output.Features == nil {
+		output.Features = map[string]bool{}
+	}
+	if u.overrides.PostingOdds != nil {
+		output.PostingOdds = *u.overrides.PostingOdds
+	}
+	if u.overrides.BackupFreq != nil {
+		output.BackupFreq = *u.overrides.BackupFreq
+	}
+	if u.overrides.MaxLength != nil {
+		output.MaxLength = *u.overrides.MaxLength
+	}
+	if u.overrides.TargetRate != nil {
+		output.TargetRate = *u.overrides.TargetRate
+	}
+	if u.overrides.QuietHours != nil {
+		output.QuietHours = *u.overrides.QuietHours
+	}
+	if u.overrides.GoodEmoji != nil {
+		output.GoodEmoji = *u.overrides.GoodEmoji
+	}
+	if u.overrides.BadEmoji != nil {
+		output.BadEmoji = *u.overrides.BadEmoji
+	}
+	maps.Copy(output.Features, u.overrides.Features)
+	return output
+}
+
+// SetPostingOdds changes the odds out of 100 of replying to a message
+func (u *ServSync) SetPostingOdds(odds uint) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides.PostingOdds = &odds
+}
+
+// SetBackupFreq changes how many messages there are between saves
+func (u *ServSync) SetBackupFreq(freq uint64) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides.BackupFreq = &freq
+}
+
+// SetMaxLength changes the most words in a generated sentence
+func (u *ServSync) SetMaxLength(length int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides.MaxLength = &length
+}
+
+// SetTargetRate changes how many messages an hour the bot aims for in each channel, 0 goes back to fixed odds
+func (u *ServSync) SetTargetRate(rate uint) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides.TargetRate = &rate
+}
+
+// SetQuietHours changes when the bot doesn't post on its own
+func (u *ServSync) SetQuietHours(quiet QuietHours) error {
+	if err := quiet.validate(); err != nil {
+		return err
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides.QuietHours = &quiet
+	return nil
+}
+
+// SetReactions changes which reactions count as good or bad, nil goes back to the defaults
+func (u *ServSync) SetReactions(good bool, emoji []string) {
+	var set *[]string
+	if emoji != nil {
+		set = &emoji
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if good {
+		u.overrides.GoodEmoji = set
+	} else {
+		u.overrides.BadEmoji = set
+	}
+}
+
+// Reaction checks if an emoji is a good or bad reaction, giving 1, -1 or 0 for neither
+func (s Settings) Reaction(emoji string) int {
+	switch {
+	case slices.Contains(s.GoodEmoji, emoji):
+		return 1
+	case slices.Contains(s.BadEmoji, emoji):
+		return -1
+	}
+	return 0
+}
+
+// SetFeature turns a feature on or off
+func (u *ServSync) SetFeature(feature string, on bool) error {
+	if !slices.Contains(Features, feature) {
+		return ErrUnknownFeature
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.overrides.Features == nil {
+		u.overrides.Features = map[string]bool{}
+	}
+	u.overrides.Features[feature] = on
+	return nil
+}
+
+// ResetSettings goes back to using the defaults for everything
+func (u *ServSync) ResetSettings() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.overrides = overrides{}
+}
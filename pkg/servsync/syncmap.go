@@ -2,6 +2,7 @@ package servsync
 
 import (
 	"encoding/json"
+	"errors"
 	"sync"
 )
 
@@ -33,14 +34,33 @@ func (u *SyncMap) Delete(key string) {
 	u.smap.Delete(key)
 }
 
-func (u SyncMap) MarshalJSON() ([]byte, error) {
-	var sMap map[string]ServSync = map[string]ServSync{}
+// Range calls f for every value in the map, stopping if f returns false
+func (u *SyncMap) Range(f func(key string, val *ServSync) bool) {
+	u.smap.Range(func(key, value any) bool {
+		return f(key.(string), value.(*ServSync))
+	})
+}
+
+// SaveAll saves every server's chain, carrying on past any that fail
+func (u *SyncMap) SaveAll() error {
+	var errs []error
+	u.Range(func(key string, val *ServSync) bool {
+		if err := val.Save(); err != nil {
+			errs = append(errs, errors.New("failed to save "+val.FileName+": "+err.Error()))
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+func (u *SyncMap) MarshalJSON() ([]byte, error) {
+	var sMap map[string]*ServSync = map[string]*ServSync{}
 
 	u.smap.Range(func(key, value any) bool {
 		mKey := key.(string)
 		mValue := value.(*ServSync)
 
-		sMap[mKey] = *mValue
+		sMap[mKey] = mValue
 
 		return true
 	})
@@ -49,7 +69,7 @@ func (u SyncMap) MarshalJSON() ([]byte, error) {
 }
 
 func (u *SyncMap) UnmarshalJSON(data []byte) error {
-	var sMap map[string]ServSync
+	var sMap map[string]*ServSync
 
 	if err := json.Unmarshal(data, &sMap); err != nil {
 		return err
@@ -57,9 +77,8 @@ func (u *SyncMap) UnmarshalJSON(data []byte) error {
 
 	u.smap = sync.Map{}
 
-	for key := range sMap {
-		val1 := sMap[key]
-		u.smap.Store(key, &val1)
+	for key, val := range sMap {
+		u.smap.Store(key, val)
 	}
 
 	return nil
@@ -0,0 +1,59 @@
+package servsync
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Short term memory of what each channel has been talking about, so replies can stay on theme
+// None of this is saved, it's only meant to last a conversation
+
+var (
+	TopicMemory = 10               // Most topic words remembered per channel
+	TopicExpiry = 15 * time.Minute // How long a topic word is remembered for
+	timeNow     = time.Now         // Swapped out in tests
+)
+
+type topic struct {
+	word string
+	at   time.Time
+}
+
+// RememberTopics adds words to a channel's topics, words it already had are moved to the front
+func (u *ServSync) RememberTopics(channelId string, words []string) {
+	if len(words) == 0 {
+		return
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.topics == nil {
+		u.topics = map[string][]topic{}
+	}
+	now := timeNow()
+	topics := u.topics[channelId]
+	for _, word := range words {
+		topics = slices.DeleteFunc(topics, func(t topic) bool { return strings.EqualFold(t.word, word) })
+		topics = append(topics, topic{word, now})
+	}
+	if len(topics) > TopicMemory {
+		topics = topics[len(topics)-TopicMemory:]
+	}
+	u.topics[channelId] = topics
+}
+
+// Topics gets the words a channel has been talking about recently, newest first
+func (u *ServSync) Topics(channelId string) []string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	output := []string{}
+	cutoff := timeNow().Add(-TopicExpiry)
+	topics := u.topics[channelId]
+	for idx := len(topics) - 1; idx >= 0; idx-- {
+		if topics[idx].at.Before(cutoff) {
+			break
+		}
+		output = append(output, topics[idx].word)
+	}
+	return output
+}